@@ -3,6 +3,9 @@ package zql
 import (
 	"errors"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // 正则解析 `(?P<abc>Hello)(.*)(?P<cba>Go).`
@@ -33,3 +36,106 @@ func RegStrToMap(regStr string, str string) (map[string]string, error) {
 	}
 	return strMap, nil
 }
+
+// 按分隔符切割字符串，忽略小括号和单引号中的分隔符
+func splitTopLevel(str string, sep rune) []string {
+	list := make([]string, 0)
+	cengji := 0
+	quote := false
+	start := 0
+	for k, v := range str {
+		switch {
+		case v == '\'':
+			quote = !quote
+		case quote:
+			continue
+		case v == '(':
+			cengji++
+		case v == ')':
+			cengji--
+		case v == sep && cengji == 0:
+			list = append(list, str[start:k])
+			start = k + 1
+		}
+	}
+	return append(list, str[start:])
+}
+
+// 拆分时间间隔 5m -> 5, m
+// 单位为 y(年) q(季度) mo(月) w(周) d h m s，查询已转小写，月份写作mo
+func splitInterval(str string) (int, string, error) {
+	str = strings.TrimSpace(str)
+	k := 0
+	for k < len(str) && str[k] >= '0' && str[k] <= '9' {
+		k++
+	}
+	if k == 0 || k == len(str) {
+		return 0, "", errors.New("Time interval format error:" + str)
+	}
+	num, err := strconv.Atoi(str[:k])
+	if err != nil {
+		return 0, "", errors.New("Time interval format error:" + str)
+	}
+	unit := str[k:]
+	switch unit {
+	case "y", "q", "mo", "w", "d", "h", "m", "s":
+		return num, unit, nil
+	}
+	return 0, "", errors.New("Time interval unit error:" + str)
+}
+
+// 年、季度、月不是固定长度，按日历计算
+func isCalendarUnit(unit string) bool {
+	return unit == "y" || unit == "q" || unit == "mo"
+}
+
+// 按周分组从周一开始，1970-01-05是周一，其它单位从1970-01-01开始
+func intervalOffset(unit string) int64 {
+	if unit == "w" {
+		return 4 * 24 * 60 * 60
+	}
+	return 0
+}
+
+// 时间所在分组的开始时间，按UTC对齐
+// 年、季度、月按日历从2000-01-01开始每num个单位一组，与mongodb的$dateTrunc一致，其它按固定秒数对齐，周从周一开始
+func intervalStart(t time.Time, interval string) (time.Time, error) {
+	num, unit, err := splitInterval(interval)
+	if err != nil || num <= 0 {
+		return t, errors.New("Query keywords 'group by' error")
+	}
+	t = t.UTC()
+	if isCalendarUnit(unit) {
+		months := intervalMonths(num, unit)
+		count := (t.Year()-2000)*12 + int(t.Month()) - 1
+		count -= (count%months + months) % months
+		return time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, count, 0), nil
+	}
+	step, err := ChaDateTime(interval)
+	if err != nil {
+		return t, errors.New("Query keywords 'group by' error")
+	}
+	sec := t.Unix() - intervalOffset(unit)
+	return time.Unix(t.Unix()-(sec%step+step)%step, 0).UTC(), nil
+}
+
+// 下一个分组的开始时间
+func intervalNext(t time.Time, interval string) time.Time {
+	num, unit, _ := splitInterval(interval)
+	if isCalendarUnit(unit) {
+		return t.AddDate(0, intervalMonths(num, unit), 0)
+	}
+	step, _ := ChaDateTime(interval)
+	return t.Add(time.Duration(step) * time.Second)
+}
+
+// 日历间隔的月数
+func intervalMonths(num int, unit string) int {
+	switch unit {
+	case "y":
+		return num * 12
+	case "q":
+		return num * 3
+	}
+	return num
+}
@@ -0,0 +1,93 @@
+package zql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// GetElasticsearchQuery 使用官方客户端执行查询，transport可以是*elasticsearch.Client，index为空时使用ElasticIndices
+// 需要命中总数、composite 聚合下一页游标等信息时使用GetElasticsearchResult
+func (zql *Zql) GetElasticsearchQuery(ctx context.Context, transport esapi.Transport, index string) ([]map[string]interface{}, error) {
+	result, err := zql.GetElasticsearchResult(ctx, transport, index)
+	if err != nil {
+		return nil, err
+	}
+	return result.Rows, nil
+}
+
+// 执行_search请求，返回解析后的结果
+func (zql *Zql) elasticSearch(ctx context.Context, transport esapi.Transport, index string, dsl map[string]interface{}) (map[string]interface{}, error) {
+	indices, ignore, err := zql.elasticSearchIndices(index)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(dsl)
+	if err != nil {
+		return nil, err
+	}
+	return elasticDo(ctx, transport, esapi.SearchRequest{Index: indices, Body: bytes.NewReader(body), IgnoreUnavailable: ignore})
+}
+
+// 查询的索引，index为空时使用IndexPattern或Prefix+From，使用模板时忽略不存在的索引
+func (zql *Zql) elasticSearchIndices(index string) ([]string, *bool, error) {
+	if index != "" {
+		return []string{index}, nil, nil
+	}
+	opts := zql.elasticOptions(ElasticVersionDefault)
+	indices, err := zql.elasticIndices(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.IndexPattern == "" {
+		return indices, nil, nil
+	}
+	ignore := true
+	return indices, &ignore, nil
+}
+
+// 执行请求，数字解析为json.Number，避免排序值等长整数丢失精度
+func elasticDo(ctx context.Context, transport esapi.Transport, req esapi.Request) (map[string]interface{}, error) {
+	res, err := req.Do(ctx, transport)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resp := make(map[string]interface{})
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&resp)
+	// 错误响应不一定是json，如代理返回的html，此时使用http状态
+	if res.IsError() {
+		if err != nil {
+			return nil, errors.New("Elasticsearch error: " + res.Status())
+		}
+		return nil, elasticError(resp, res.Status())
+	}
+	if err != nil {
+		return nil, errors.New("Analytical result set 'json' error")
+	}
+	return resp, nil
+}
+
+// 错误信息，优先使用root_cause中的原因
+func elasticError(resp map[string]interface{}, status string) error {
+	info, ok := resp["error"].(map[string]interface{})
+	if !ok {
+		return errors.New("Elasticsearch error: " + status)
+	}
+	if causes, ok := info["root_cause"].([]interface{}); ok && len(causes) > 0 {
+		if cause, ok := causes[0].(map[string]interface{}); ok {
+			if reason, ok := cause["reason"].(string); ok {
+				return errors.New(reason)
+			}
+		}
+	}
+	if reason, ok := info["reason"].(string); ok {
+		return errors.New(reason)
+	}
+	return errors.New("Elasticsearch error: " + status)
+}
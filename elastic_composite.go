@@ -0,0 +1,105 @@
+package zql
+
+import (
+	"errors"
+)
+
+// composite 聚合名称
+const elasticCompositeName = "group"
+
+// 分组使用composite聚合，每个分组字段为一个source，after从游标中读取
+func (zql *Zql) elasticCompositeAggs(levels []*elasticAggLevel, metrics map[string]interface{}, counts map[string]bool, size int, opts *ElasticOptions) (map[string]interface{}, error) {
+	if opts.Version < 6 {
+		return nil, errors.New("Composite aggregation requires elasticsearch 6.x or later")
+	}
+	orders := make(map[string]string)
+	for _, v := range zql.ParseOrderBy() {
+		orders[v.Field] = elasticOrder(v.Desc)
+		if _, ok := metrics[v.Field]; ok || counts[v.Field] {
+			return nil, errors.New("Composite aggregation can only order by 'group by' fields:" + v.Field)
+		}
+	}
+	sources := make([]interface{}, 0, len(levels))
+	for _, level := range levels {
+		var source map[string]interface{}
+		if level.interval != "" {
+			body, err := elasticDateHistogram(level, opts)
+			if err != nil {
+				return nil, err
+			}
+			source = map[string]interface{}{"date_histogram": body}
+		} else {
+			source = map[string]interface{}{"terms": map[string]interface{}{"field": level.field}}
+		}
+		for _, body := range source {
+			// 保留分组字段不存在的文档，missing_bucket从6.4开始支持，版本只有大版本号，6.x不使用
+			if opts.Version >= 7 {
+				body.(map[string]interface{})["missing_bucket"] = true
+			}
+			if order, ok := orders[level.name]; ok {
+				body.(map[string]interface{})["order"] = order
+			}
+		}
+		sources = append(sources, map[string]interface{}{level.name: source})
+	}
+	composite := map[string]interface{}{"size": size, "sources": sources}
+	cur, err := decodeElasticCursor(zql.After)
+	if err != nil {
+		return nil, err
+	}
+	if cur.Composite != nil {
+		composite["after"] = cur.Composite
+	}
+	agg := map[string]interface{}{"composite": composite}
+	if len(metrics) > 0 {
+		agg["aggs"] = metrics
+	}
+	return map[string]interface{}{elasticCompositeName: agg}, nil
+}
+
+// composite 聚合结果，每个分组一行
+func elasticCompositeRows(fields []*SelectField, aggs map[string]interface{}, rows *[]map[string]interface{}) {
+	agg, _ := aggs[elasticCompositeName].(map[string]interface{})
+	buckets, _ := agg["buckets"].([]interface{})
+	for _, v := range buckets {
+		bucket, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		row := make(map[string]interface{})
+		if key, ok := bucket["key"].(map[string]interface{}); ok {
+			for name, val := range key {
+				row[name] = val
+			}
+		}
+		for name, val := range elasticMetrics(fields, bucket) {
+			row[name] = val
+		}
+		*rows = append(*rows, row)
+	}
+}
+
+// 是否使用composite聚合分组
+func (zql *Zql) isElasticComposite(opts *ElasticOptions) bool {
+	return opts.Composite && zql.GroupBy != ""
+}
+
+// 下一页的游标，分组数少于size时为最后一页，返回空
+func (zql *Zql) elasticCompositeCursor(resp map[string]interface{}) (string, error) {
+	_, size, err := zql.ParseLimit()
+	if err != nil {
+		return "", err
+	}
+	if size < 0 {
+		size = elasticAggSize
+	}
+	aggs, _ := resp["aggregations"].(map[string]interface{})
+	agg, _ := aggs[elasticCompositeName].(map[string]interface{})
+	buckets, _ := agg["buckets"].([]interface{})
+	afterKey, ok := agg["after_key"].(map[string]interface{})
+	if !ok || len(buckets) < size {
+		return "", nil
+	}
+	cur := &elasticCursor{Composite: afterKey}
+	return cur.encode()
+}
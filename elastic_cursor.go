@@ -0,0 +1,240 @@
+package zql
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// 游标分页没有limit时每页条数，PIT和scroll的保持时间
+const (
+	elasticPageSize  = 1000
+	elasticKeepAlive = time.Minute
+	// PIT的keep_alive使用elasticsearch时间单位写法，time.Duration的1m0s会被拒绝
+	elasticPitKeepAlive = "1m"
+)
+
+// 游标只使用小写字母和数字，解码时不区分大小写
+var elasticCursorEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ElasticPage 游标分页的一页数据
+type ElasticPage struct {
+	Rows   []map[string]interface{}
+	Cursor string // 下一页游标，没有更多数据时为空
+}
+
+// 游标内容，8版本以后使用PIT和search_after，之前的版本使用scroll
+type elasticCursor struct {
+	Pit    string        `json:"p,omitempty"`
+	After  []interface{} `json:"a,omitempty"`
+	Scroll string        `json:"s,omitempty"`
+	// composite 聚合的after_key
+	Composite map[string]interface{} `json:"c,omitempty"`
+}
+
+func (cursor *elasticCursor) encode() (string, error) {
+	js, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return elasticCursorEncoding.EncodeToString(js), nil
+}
+
+func decodeElasticCursor(str string) (*elasticCursor, error) {
+	cursor := &elasticCursor{}
+	if str == "" {
+		return cursor, nil
+	}
+	js, err := elasticCursorEncoding.DecodeString(strings.ToLower(str))
+	if err != nil {
+		return nil, errors.New("Cursor format error")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+	if err = decoder.Decode(cursor); err != nil {
+		return nil, errors.New("Cursor format error")
+	}
+	return cursor, nil
+}
+
+// GetElasticsearchPage 游标分页查询，cursor为空时使用查询中的 after '<cursor>'，都为空时查询第一页
+// 每页条数为limit，返回的Cursor用于查询下一页，最后一页时为空并释放PIT或scroll
+func (zql *Zql) GetElasticsearchPage(ctx context.Context, transport esapi.Transport, index, cursor string) (*ElasticPage, error) {
+	if cursor == "" {
+		cursor = zql.After
+	}
+	cur, err := decodeElasticCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	opts := zql.elasticOptions(ElasticVersionDefault)
+	dsl, size, err := zql.elasticPageDSL(opts)
+	if err != nil {
+		return nil, err
+	}
+	// composite 聚合按after_key分页
+	if zql.isElasticComposite(opts) {
+		return zql.elasticCompositePage(ctx, transport, index, dsl, cur, opts)
+	}
+	var resp map[string]interface{}
+	if opts.Version >= 8 {
+		resp, err = zql.elasticPitSearch(ctx, transport, index, dsl, cur)
+	} else {
+		resp, err = zql.elasticScrollSearch(ctx, transport, index, dsl, cur)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 下一页游标，需要在转换数字前取出排序值
+	hits, _ := resp["hits"].(map[string]interface{})
+	list, _ := hits["hits"].([]interface{})
+	next := &elasticCursor{}
+	if len(list) >= size {
+		if opts.Version >= 8 {
+			next.Pit = cur.Pit
+			if pit, ok := resp["pit_id"].(string); ok {
+				next.Pit = pit
+			}
+			if last, ok := list[len(list)-1].(map[string]interface{}); ok {
+				next.After, _ = last["sort"].([]interface{})
+			}
+		} else {
+			next.Scroll, _ = resp["_scroll_id"].(string)
+		}
+	} else {
+		// 最后一页释放服务端资源
+		zql.elasticCloseCursor(ctx, transport, resp, cur)
+	}
+	page := &ElasticPage{}
+	if next.Pit != "" || next.Scroll != "" {
+		if page.Cursor, err = next.encode(); err != nil {
+			return nil, err
+		}
+	}
+	page.Rows, err = zql.elasticRows(resp, opts)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// 分页查询的DSL，不能有分组和limit偏移量
+func (zql *Zql) elasticPageDSL(opts *ElasticOptions) (map[string]interface{}, int, error) {
+	fields, err := zql.ParseSelect()
+	if err != nil {
+		return nil, 0, err
+	}
+	if zql.isElasticAggregate(fields) && !zql.isElasticComposite(opts) {
+		return nil, 0, errors.New("Cursor pagination does not support 'group by' or aggregate functions")
+	}
+	skip, size, err := zql.ParseLimit()
+	if err != nil {
+		return nil, 0, err
+	}
+	if skip > 0 {
+		return nil, 0, errors.New("Cursor pagination does not support 'limit' offset")
+	}
+	if size < 0 {
+		size = elasticPageSize
+	}
+	dsl, err := zql.elasticDSL(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !zql.isElasticComposite(opts) {
+		dsl["size"] = size
+	}
+	return dsl, size, nil
+}
+
+// composite 聚合的一页分组
+func (zql *Zql) elasticCompositePage(ctx context.Context, transport esapi.Transport, index string, dsl map[string]interface{}, cur *elasticCursor, opts *ElasticOptions) (*ElasticPage, error) {
+	composite := dsl["aggs"].(map[string]interface{})[elasticCompositeName].(map[string]interface{})["composite"].(map[string]interface{})
+	delete(composite, "after")
+	if cur.Composite != nil {
+		composite["after"] = cur.Composite
+	}
+	resp, err := zql.elasticSearch(ctx, transport, index, dsl)
+	if err != nil {
+		return nil, err
+	}
+	page := &ElasticPage{}
+	if page.Cursor, err = zql.elasticCompositeCursor(resp); err != nil {
+		return nil, err
+	}
+	if page.Rows, err = zql.elasticRows(resp, opts); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// PIT和search_after，排序最后加上_shard_doc保证顺序唯一
+func (zql *Zql) elasticPitSearch(ctx context.Context, transport esapi.Transport, index string, dsl map[string]interface{}, cur *elasticCursor) (map[string]interface{}, error) {
+	if cur.Pit == "" {
+		indices, ignore, err := zql.elasticSearchIndices(index)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := elasticDo(ctx, transport, esapi.OpenPointInTimeRequest{Index: indices, KeepAlive: elasticPitKeepAlive, IgnoreUnavailable: ignore})
+		if err != nil {
+			return nil, err
+		}
+		cur.Pit, _ = resp["id"].(string)
+		if cur.Pit == "" {
+			return nil, errors.New("Open point in time error")
+		}
+	}
+	dsl["pit"] = map[string]interface{}{"id": cur.Pit, "keep_alive": elasticPitKeepAlive}
+	sorts, _ := dsl["sort"].([]interface{})
+	dsl["sort"] = append(sorts, map[string]interface{}{"_shard_doc": "asc"})
+	if len(cur.After) > 0 {
+		dsl["search_after"] = cur.After
+	}
+	body, err := json.Marshal(dsl)
+	if err != nil {
+		return nil, err
+	}
+	// 使用PIT时请求中不能有索引名
+	return elasticDo(ctx, transport, esapi.SearchRequest{Body: bytes.NewReader(body)})
+}
+
+// scroll 分页，没有排序时按_doc排序
+func (zql *Zql) elasticScrollSearch(ctx context.Context, transport esapi.Transport, index string, dsl map[string]interface{}, cur *elasticCursor) (map[string]interface{}, error) {
+	if cur.Scroll != "" {
+		return elasticDo(ctx, transport, esapi.ScrollRequest{ScrollID: cur.Scroll, Scroll: elasticKeepAlive})
+	}
+	if _, ok := dsl["sort"]; !ok {
+		dsl["sort"] = []interface{}{"_doc"}
+	}
+	indices, ignore, err := zql.elasticSearchIndices(index)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(dsl)
+	if err != nil {
+		return nil, err
+	}
+	return elasticDo(ctx, transport, esapi.SearchRequest{Index: indices, Body: bytes.NewReader(body), Scroll: elasticKeepAlive, IgnoreUnavailable: ignore})
+}
+
+// 关闭PIT或清除scroll，失败时等待服务端超时释放
+func (zql *Zql) elasticCloseCursor(ctx context.Context, transport esapi.Transport, resp map[string]interface{}, cur *elasticCursor) {
+	if cur.Pit != "" {
+		pit := cur.Pit
+		if id, ok := resp["pit_id"].(string); ok {
+			pit = id
+		}
+		body, _ := json.Marshal(map[string]interface{}{"id": pit})
+		elasticDo(ctx, transport, esapi.ClosePointInTimeRequest{Body: bytes.NewReader(body)})
+		return
+	}
+	if scroll, ok := resp["_scroll_id"].(string); ok && scroll != "" {
+		elasticDo(ctx, transport, esapi.ClearScrollRequest{ScrollID: []string{scroll}})
+	}
+}
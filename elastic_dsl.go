@@ -0,0 +1,769 @@
+package zql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 分组没有limit时每层返回的分组数
+const elasticAggSize = 100
+
+// 最内层分组在nested路径中时，count(*)使用reverse_nested聚合统计根文档数
+const elasticRootCountName = "root_doc_count"
+
+// ElasticDSL 生成elasticsearch查询DSL(JSON对象)，不需要数据库连接，写法由ElasticOptions.Version决定
+func (zql *Zql) ElasticDSL() (map[string]interface{}, error) {
+	return zql.elasticDSL(zql.elasticOptions(ElasticVersionDefault))
+}
+
+// ElasticDSLStr 查询DSL的JSON字符串，相当于orm打印sql
+func (zql *Zql) ElasticDSLStr() (string, error) {
+	dsl, err := zql.ElasticDSL()
+	if err != nil {
+		return "", err
+	}
+	return elasticJSON(dsl)
+}
+
+// 转为JSON字符串，不转义&<>
+func elasticJSON(dsl map[string]interface{}) (string, error) {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(dsl); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// 组织整个查询
+func (zql *Zql) elasticDSL(opts *ElasticOptions) (map[string]interface{}, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, errors.New("Query string does not exist 'select|from'")
+	}
+	// 空分组填充只有mongodb支持
+	if fill := zql.ParseFill(); fill != "" && fill != "none" {
+		return nil, errors.New("Query keywords 'fill(" + fill + ")' is not supported by elasticsearch")
+	}
+	dsl := make(map[string]interface{})
+	// 条件
+	tree, err := zql.WhereTree()
+	if err != nil {
+		return nil, err
+	}
+	if tree != nil {
+		query, err := elasticQuery(tree, opts)
+		if err != nil {
+			return nil, err
+		}
+		dsl["query"] = query
+	}
+	fields, err := zql.ParseSelect()
+	if err != nil {
+		return nil, err
+	}
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return nil, err
+	}
+	if skip > 0 && opts.Composite && zql.GroupBy != "" {
+		return nil, errors.New("Composite aggregation does not support 'limit' offset, use cursor")
+	}
+	// 分组或聚合查询不需要返回文档
+	if zql.isElasticAggregate(fields) {
+		aggs, err := zql.elasticAggs(fields, limit, opts)
+		if err != nil {
+			return nil, err
+		}
+		dsl["size"] = 0
+		if len(aggs) > 0 {
+			dsl["aggs"] = aggs
+		}
+		// 没有分组时count(*)使用命中总数
+		if zql.GroupBy == "" && opts.Version >= 7 {
+			dsl["track_total_hits"] = true
+		}
+		return dsl, nil
+	}
+	// 返回字段，别名在结果中处理，只有高亮时返回全部字段
+	source := make([]string, 0)
+	wildcard := false
+	for _, v := range fields {
+		if v.Func == "highlight" {
+			highlight, err := elasticHighlight(v, opts)
+			if err != nil {
+				return nil, err
+			}
+			dsl["highlight"] = highlight
+			continue
+		}
+		if v.Func != "" {
+			return nil, errors.New("Field 'select' format error:" + v.Expr)
+		}
+		if v.Field == "*" {
+			wildcard = true
+			continue
+		}
+		source = append(source, v.Field)
+	}
+	if len(source) > 0 && !wildcard {
+		dsl["_source"] = source
+	}
+	// 排序
+	if zql.OrderBy != "" {
+		sorts := make([]interface{}, 0)
+		for _, v := range zql.ParseOrderBy() {
+			field, err := opts.aggField(v.Field, "order by")
+			if err != nil {
+				return nil, err
+			}
+			sorts = append(sorts, map[string]interface{}{field: map[string]interface{}{"order": elasticOrder(v.Desc)}})
+		}
+		dsl["sort"] = sorts
+	}
+	// 分页
+	if skip > 0 {
+		dsl["from"] = skip
+	}
+	if limit >= 0 {
+		dsl["size"] = limit
+	}
+	return dsl, nil
+}
+
+func elasticOrder(desc bool) string {
+	if desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// 有分组或者select中有聚合函数
+func (zql *Zql) isElasticAggregate(fields []*SelectField) bool {
+	if zql.GroupBy != "" {
+		return true
+	}
+	for _, v := range fields {
+		if v.Func != "" && v.Func != "highlight" {
+			return true
+		}
+	}
+	return false
+}
+
+// 条件树转为bool查询，and为must，or为should
+func elasticQuery(node *WhereNode, opts *ElasticOptions) (map[string]interface{}, error) {
+	if node.IsLeaf() {
+		query, err := elasticLeaf(node, opts)
+		if err != nil {
+			return nil, err
+		}
+		if path := opts.nestedPath(node.Field); path != "" {
+			return elasticNestedQuery(path, []interface{}{query}), nil
+		}
+		return query, nil
+	}
+	list := make([]interface{}, 0, len(node.Children))
+	// and 中同一nested路径的条件合并为一个nested查询，nested为路径对应的条件和在list中的位置
+	nested := make(map[string][]interface{})
+	positions := make(map[string]int)
+	for _, v := range node.Children {
+		path := ""
+		if node.Op == "and" && v.IsLeaf() {
+			path = opts.nestedPath(v.Field)
+		}
+		if path == "" {
+			query, err := elasticQuery(v, opts)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, query)
+			continue
+		}
+		query, err := elasticLeaf(v, opts)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := positions[path]; !ok {
+			positions[path] = len(list)
+			list = append(list, nil)
+		}
+		nested[path] = append(nested[path], query)
+	}
+	for path, k := range positions {
+		list[k] = elasticNestedQuery(path, nested[path])
+	}
+	if node.Op == "or" {
+		return map[string]interface{}{"bool": map[string]interface{}{"should": list, "minimum_should_match": 1}}, nil
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"must": list}}, nil
+}
+
+// 单个条件
+func elasticLeaf(node *WhereNode, opts *ElasticOptions) (map[string]interface{}, error) {
+	field := opts.field(node.Field)
+	switch node.Exp {
+	case "=", "!=":
+		val, err := elasticValue(node.Value)
+		if err != nil {
+			return nil, err
+		}
+		query := elasticExact(node.Field, val, opts)
+		if node.Exp == "!=" {
+			return map[string]interface{}{"bool": map[string]interface{}{"must_not": []interface{}{query}}}, nil
+		}
+		return query, nil
+	case ">", ">=", "<", "<=":
+		val, err := elasticValue(node.Value)
+		if err != nil {
+			return nil, err
+		}
+		op := map[string]string{">": "gt", ">=": "gte", "<": "lt", "<=": "lte"}[node.Exp]
+		return map[string]interface{}{"range": map[string]interface{}{field: map[string]interface{}{op: val}}}, nil
+	case "in":
+		if node.Value[:1] != "(" || node.Value[len(node.Value)-1:] != ")" {
+			return nil, errors.New("Single condition error:" + node.Value)
+		}
+		should := make([]interface{}, 0)
+		for _, v := range splitTopLevel(node.Value[1:len(node.Value)-1], ',') {
+			val, err := elasticValue(v)
+			if err != nil {
+				return nil, err
+			}
+			should = append(should, elasticExact(node.Field, val, opts))
+		}
+		return map[string]interface{}{"bool": map[string]interface{}{"should": should, "minimum_should_match": 1}}, nil
+	case "like", "ilike":
+		wildcard := map[string]interface{}{"value": elasticWildcard(strings.Trim(node.Value, "'"))}
+		if node.Exp == "ilike" {
+			if opts.Version < 7 {
+				return nil, errors.New("Operator 'ilike' needs elasticsearch 7.10 or later")
+			}
+			wildcard["case_insensitive"] = true
+		}
+		return map[string]interface{}{"wildcard": map[string]interface{}{field: wildcard}}, nil
+	case "regexp", "=~":
+		return map[string]interface{}{"regexp": map[string]interface{}{field: strings.Trim(node.Value, "'")}}, nil
+	case "match", "phrase", "term", "fuzzy", "multi_match", "query_string":
+		return elasticSearchFunc(node, opts)
+	}
+	return nil, errors.New("Operator '" + node.Exp + "' is not supported by elasticsearch")
+}
+
+// 精确匹配，text 字段有keyword子字段时使用term查询，否则使用短语匹配
+func elasticExact(name string, val interface{}, opts *ElasticOptions) map[string]interface{} {
+	field, keyword := opts.exactField(name)
+	if keyword {
+		return map[string]interface{}{"term": map[string]interface{}{field: val}}
+	}
+	return map[string]interface{}{"match_phrase": map[string]interface{}{field: val}}
+}
+
+// 全文检索函数，参与相关度评分，可以 order by _score
+func elasticSearchFunc(node *WhereNode, opts *ElasticOptions) (map[string]interface{}, error) {
+	field := opts.field(node.Field)
+	val, err := elasticValue(node.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch node.Exp {
+	case "match":
+		return map[string]interface{}{"match": map[string]interface{}{field: val}}, nil
+	case "phrase":
+		return map[string]interface{}{"match_phrase": map[string]interface{}{field: val}}, nil
+	case "term":
+		return map[string]interface{}{"term": map[string]interface{}{field: val}}, nil
+	case "fuzzy":
+		// 没有指定编辑距离时按词长自动选择
+		var fuzziness interface{} = "AUTO"
+		if len(node.Args) == 3 {
+			if fuzziness, err = elasticValue(node.Args[2]); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"fuzzy": map[string]interface{}{field: map[string]interface{}{"value": val, "fuzziness": fuzziness}}}, nil
+	case "multi_match":
+		fields := make([]string, 0, len(node.Args)-1)
+		for _, v := range node.Args[1:] {
+			fields = append(fields, opts.field(v))
+		}
+		return map[string]interface{}{"multi_match": map[string]interface{}{"query": val, "fields": fields}}, nil
+	}
+	return map[string]interface{}{"query_string": map[string]interface{}{"query": val}}, nil
+}
+
+// 条件值，相对时间使用日期运算 now-1h，指定时间转为带时区的RFC3339格式
+func elasticValue(str string) (interface{}, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, errors.New("Single condition value is empty")
+	}
+	if len(str) >= 2 && str[:1] == "'" && str[len(str)-1:] == "'" {
+		return str[1 : len(str)-1], nil
+	}
+	switch str {
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	isDateArr := strings.Split(str, "-")
+	if strings.TrimSpace(isDateArr[0]) == "now()" {
+		return elasticDateMath(str)
+	}
+	if strings.Index(str, "date(") == 0 && str[len(str)-1:] == ")" {
+		dateStr := strings.Trim(strings.TrimSpace(str[5:len(str)-1]), "'")
+		dateTime, err := time.ParseInLocation("2006-01-02 15:04:05", dateStr, time.Local)
+		if err != nil {
+			return nil, errors.New("Query keywords 'where' error:" + err.Error())
+		}
+		return dateTime.Format(time.RFC3339), nil
+	}
+	if num, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return num, nil
+	}
+	if num, err := strconv.ParseFloat(str, 64); err == nil {
+		return num, nil
+	}
+	return str, nil
+}
+
+// like 转为wildcard，% 匹配任意字符，_ 匹配单个字符，原有的*?\转义
+func elasticWildcard(val string) string {
+	str := ""
+	for _, v := range val {
+		switch v {
+		case '%':
+			str += "*"
+		case '_':
+			str += "?"
+		case '*', '?', '\\':
+			str += `\` + string(v)
+		default:
+			str += string(v)
+		}
+	}
+	return str
+}
+
+// 分组层级，时间分组在最外层，名称为time，字段分组名称为字段名
+type elasticAggLevel struct {
+	name     string
+	field    string
+	interval string // 按时间分组的间隔
+	// 间隔类型 fixed 或 calendar
+	intervalType string
+	nested       string // 字段所在的nested路径
+	// 从上一层切换到本层nested路径需要的nested或reverse_nested聚合
+	wraps []map[string]interface{}
+}
+
+// 分组层级列表
+func (zql *Zql) elasticAggLevels(opts *ElasticOptions) []*elasticAggLevel {
+	groupFields, interval := zql.ParseGroupBy()
+	levels := make([]*elasticAggLevel, 0)
+	if interval != "" {
+		// time(5m, field=@timestamp, type=calendar) 中的参数优先于配置
+		level := &elasticAggLevel{name: "time", field: zql.elasticTimeGroupField(opts), interval: interval, intervalType: opts.HistogramInterval}
+		args := zql.ParseTimeGroup()
+		if intervalType, ok := args["type"]; ok {
+			level.intervalType = intervalType
+		}
+		levels = append(levels, level)
+	}
+	for _, v := range groupFields {
+		levels = append(levels, &elasticAggLevel{name: v, field: opts.field(v)})
+	}
+	nested := ""
+	for _, v := range levels {
+		v.nested = opts.nestedPath(v.field)
+		v.wraps = elasticNestedWraps(nested, v.nested)
+		nested = v.nested
+	}
+	return levels
+}
+
+// 按时间分组的字段，time(5m, field=@timestamp)中的字段优先于配置
+func (zql *Zql) elasticTimeGroupField(opts *ElasticOptions) string {
+	if field, ok := zql.ParseTimeGroup()["field"]; ok {
+		return field
+	}
+	return opts.TimeField
+}
+
+// 最内层分组所在的nested路径，聚合函数从此路径切换到字段所在路径
+func elasticInnerNested(levels []*elasticAggLevel) string {
+	if len(levels) == 0 {
+		return ""
+	}
+	return levels[len(levels)-1].nested
+}
+
+// 分组和聚合，多个分组字段逐层嵌套，聚合函数放在最内层
+func (zql *Zql) elasticAggs(fields []*SelectField, limit int, opts *ElasticOptions) (map[string]interface{}, error) {
+	levels := zql.elasticAggLevels(opts)
+	grouped := make(map[string]bool)
+	for _, v := range levels {
+		grouped[v.name] = true
+		if v.interval != "" {
+			continue
+		}
+		field, err := opts.aggField(v.field, "group by")
+		if err != nil {
+			return nil, err
+		}
+		v.field = field
+	}
+	// 聚合函数，counts为count(*)的名称
+	metrics := make(map[string]interface{})
+	counts := map[string]bool{"doc_count": true}
+	for _, v := range fields {
+		if v.Func == "" {
+			// 普通字段必须是分组字段
+			if !grouped[v.Field] {
+				return nil, errors.New("Field 'select' must be an aggregate or a 'group by' field:" + v.Expr)
+			}
+			continue
+		}
+		switch v.Func {
+		case "count":
+			// count(*) 使用分组的doc_count
+			if v.Field != "*" {
+				field, err := opts.aggField(v.Field, "count")
+				if err != nil {
+					return nil, err
+				}
+				metrics[v.Name()] = map[string]interface{}{"value_count": map[string]interface{}{"field": field}}
+			} else {
+				counts[v.Name()] = true
+			}
+		case "avg", "sum", "max", "min":
+			metrics[v.Name()] = map[string]interface{}{v.Func: map[string]interface{}{"field": opts.field(v.Field)}}
+		default:
+			return nil, errors.New("Field 'select' format error:" + v.Expr)
+		}
+	}
+	// nested 字段上的聚合函数需要切换nested路径，wraps为包裹的层数
+	metricAggs := make(map[string]interface{})
+	wraps := make(map[string]int)
+	for _, v := range fields {
+		metric, ok := metrics[v.Name()]
+		if !ok {
+			continue
+		}
+		list := elasticNestedWraps(elasticInnerNested(levels), opts.nestedPath(v.Field))
+		wraps[v.Name()] = len(list)
+		for key, agg := range elasticWrapAgg(v.Name(), metric.(map[string]interface{}), list) {
+			metricAggs[key] = agg
+		}
+	}
+	// nested 分组的doc_count是数组元素数，count(*)需要回到根文档
+	rootCount := false
+	if elasticInnerNested(levels) != "" {
+		for name := range counts {
+			rootCount = rootCount || name != "doc_count"
+		}
+		for _, v := range zql.ParseOrderBy() {
+			rootCount = rootCount || counts[v.Field]
+		}
+		if rootCount {
+			metricAggs[elasticRootCountName] = map[string]interface{}{"reverse_nested": map[string]interface{}{}}
+		}
+	}
+	if len(levels) == 0 {
+		return metricAggs, nil
+	}
+	size := elasticAggSize
+	if limit >= 0 {
+		size = limit
+	}
+	if opts.Composite {
+		for _, v := range levels {
+			if v.nested != "" {
+				return nil, errors.New("Composite aggregation does not support nested field:" + v.name)
+			}
+		}
+		for name, n := range wraps {
+			if n > 0 {
+				return nil, errors.New("Composite aggregation does not support nested field:" + name)
+			}
+		}
+		return zql.elasticCompositeAggs(levels, metrics, counts, size, opts)
+	}
+	orders := zql.ParseOrderBy()
+	var aggs map[string]interface{}
+	for k := len(levels) - 1; k >= 0; k-- {
+		level := levels[k]
+		var body map[string]interface{}
+		if level.interval != "" {
+			histogram, err := elasticDateHistogram(level, opts)
+			if err != nil {
+				return nil, err
+			}
+			body = histogram
+			// 返回时间范围内没有数据的分组
+			if !opts.HistogramSkipEmpty {
+				body["min_doc_count"] = 0
+				if bounds := zql.elasticHistogramBounds(level.field, opts); bounds != nil {
+					body["extended_bounds"] = bounds
+				}
+			}
+		} else {
+			body = map[string]interface{}{"field": level.field, "size": size}
+		}
+		// 排序，按分组值或最内层的聚合结果
+		for _, v := range orders {
+			if v.Field == level.name {
+				key := "_key"
+				if level.interval == "" && opts.Version < 6 {
+					key = "_term"
+				}
+				body["order"] = map[string]interface{}{key: elasticOrder(v.Desc)}
+			} else if k == len(levels)-1 && counts[v.Field] && rootCount {
+				body["order"] = map[string]interface{}{elasticRootCountName: elasticOrder(v.Desc)}
+			} else if k == len(levels)-1 && counts[v.Field] {
+				body["order"] = map[string]interface{}{"_count": elasticOrder(v.Desc)}
+			} else if _, ok := metrics[v.Field]; ok && k == len(levels)-1 {
+				body["order"] = map[string]interface{}{elasticAggPath(v.Field, wraps[v.Field]): elasticOrder(v.Desc)}
+			}
+		}
+		aggType := "terms"
+		if level.interval != "" {
+			aggType = "date_histogram"
+		}
+		agg := map[string]interface{}{aggType: body}
+		if k == len(levels)-1 && len(metricAggs) > 0 {
+			agg["aggs"] = metricAggs
+		} else if aggs != nil {
+			agg["aggs"] = aggs
+		}
+		aggs = elasticWrapAgg(level.name, agg, level.wraps)
+	}
+	return aggs, nil
+}
+
+// 按时间分组，7版本以后区分固定间隔和日历间隔
+func elasticDateHistogram(level *elasticAggLevel, opts *ElasticOptions) (map[string]interface{}, error) {
+	body := map[string]interface{}{"field": level.field, "format": opts.HistogramFormat}
+	if opts.HistogramTimeZone != "" {
+		body["time_zone"] = opts.HistogramTimeZone
+	}
+	num, unit, err := splitInterval(level.interval)
+	if err != nil {
+		return nil, errors.New("Query keywords 'group by' error")
+	}
+	intervalType := level.intervalType
+	if intervalType == "" {
+		intervalType = ElasticIntervalFixed
+		if isCalendarUnit(unit) {
+			intervalType = ElasticIntervalCalendar
+		}
+	}
+	// 日历间隔 1M 1q 1w 1y 等，固定间隔没有周以上的单位，周转为天
+	interval := fmt.Sprintf("%d%s", num, elasticIntervalUnits[unit])
+	if unit == "w" && intervalType == ElasticIntervalFixed {
+		interval = fmt.Sprintf("%dd", num*7)
+		// 固定间隔从1970-01-01(周四)开始，偏移到周一
+		body["offset"] = "4d"
+	}
+	switch {
+	case intervalType != ElasticIntervalFixed && intervalType != ElasticIntervalCalendar:
+		return nil, errors.New("Interval type must be 'fixed' or 'calendar':" + intervalType)
+	case intervalType == ElasticIntervalCalendar && num != 1:
+		// 日历间隔不是固定长度，只能按1个单位分组
+		return nil, errors.New("Calendar interval must be 1:" + level.interval)
+	case intervalType == ElasticIntervalFixed && isCalendarUnit(unit):
+		return nil, errors.New("Fixed interval does not support years, quarters or months:" + level.interval)
+	case opts.Version < 7:
+		body["interval"] = interval
+	case intervalType == ElasticIntervalCalendar:
+		body["calendar_interval"] = interval
+	default:
+		body["fixed_interval"] = interval
+	}
+	return body, nil
+}
+
+// 时间间隔单位对应elasticsearch的单位，日期运算中也使用
+var elasticIntervalUnits = map[string]string{
+	"y":  "y",
+	"q":  "q",
+	"mo": "M",
+	"w":  "w",
+	"d":  "d",
+	"h":  "h",
+	"m":  "m",
+	"s":  "s",
+}
+
+// 相对时间 now()-1mo 转为日期运算 now-1M，日期运算没有季度，按月计算
+func elasticDateMath(str string) (string, error) {
+	list := strings.Split(strings.Replace(str, " ", "", -1), "-")
+	if len(list) == 1 {
+		return "now", nil
+	}
+	num, unit, err := splitInterval(list[1])
+	if err != nil || len(list) != 2 {
+		return "", errors.New("Time expression error:" + str)
+	}
+	if unit == "q" {
+		num, unit = num*3, "mo"
+	}
+	return fmt.Sprintf("now-%d%s", num, elasticIntervalUnits[unit]), nil
+}
+
+// 查询结果转为行数据，分组结果每个最内层分组一行
+func (zql *Zql) elasticRows(resp map[string]interface{}, opts *ElasticOptions) ([]map[string]interface{}, error) {
+	fields, err := zql.ParseSelect()
+	if err != nil {
+		return nil, err
+	}
+	elasticNumbers(resp)
+	rows := make([]map[string]interface{}, 0)
+	if zql.isElasticAggregate(fields) {
+		aggs, _ := resp["aggregations"].(map[string]interface{})
+		levels := zql.elasticAggLevels(opts)
+		if len(levels) == 0 {
+			// 没有分组时只有一行
+			row := elasticMetrics(fields, aggs)
+			for _, v := range fields {
+				if v.Func == "count" && v.Field == "*" {
+					row[v.Name()] = elasticTotal(resp)
+				}
+			}
+			return append(rows, row), nil
+		}
+		if zql.isElasticComposite(opts) {
+			elasticCompositeRows(fields, aggs, &rows)
+			return rows, nil
+		}
+		elasticBucketRows(levels, fields, aggs, make(map[string]interface{}), &rows)
+		return rows, nil
+	}
+	hits, _ := resp["hits"].(map[string]interface{})
+	list, _ := hits["hits"].([]interface{})
+	for _, v := range list {
+		hit, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		row, _ := hit["_source"].(map[string]interface{})
+		if row == nil {
+			row = make(map[string]interface{})
+		}
+		// 别名
+		for _, field := range fields {
+			if field.Alias == "" {
+				continue
+			}
+			if val, ok := memoryValue(row, field.Field); ok {
+				memoryDelete(row, field.Field)
+				row[field.Alias] = val
+			}
+		}
+		row["_id"] = hit["_id"] // 添加id唯一标识
+		// 相关度评分，按其它字段排序时为空
+		if score, ok := hit["_score"]; ok && score != nil {
+			row["_score"] = score
+		}
+		// 高亮片段，字段名对应片段数组
+		if highlight, ok := hit["highlight"]; ok {
+			row[elasticHighlightName(fields)] = highlight
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 逐层展开分组
+func elasticBucketRows(levels []*elasticAggLevel, fields []*SelectField, aggs map[string]interface{}, parent map[string]interface{}, rows *[]map[string]interface{}) {
+	agg := elasticUnwrapAgg(aggs, levels[0].name, len(levels[0].wraps))
+	buckets, _ := agg["buckets"].([]interface{})
+	for _, v := range buckets {
+		bucket, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		row := make(map[string]interface{}, len(parent)+len(fields))
+		for key, val := range parent {
+			row[key] = val
+		}
+		if str, ok := bucket["key_as_string"]; ok {
+			row[levels[0].name] = str
+		} else {
+			row[levels[0].name] = bucket["key"]
+		}
+		if len(levels) > 1 {
+			elasticBucketRows(levels[1:], fields, bucket, row, rows)
+			continue
+		}
+		for key, val := range elasticMetrics(fields, bucket) {
+			row[key] = val
+		}
+		*rows = append(*rows, row)
+	}
+}
+
+// 聚合函数的值，count(*)为分组的doc_count，nested分组中为根文档数
+func elasticMetrics(fields []*SelectField, bucket map[string]interface{}) map[string]interface{} {
+	row := make(map[string]interface{})
+	for _, v := range fields {
+		if v.Func == "" {
+			continue
+		}
+		if v.Func == "count" && v.Field == "*" {
+			row[v.Name()] = bucket["doc_count"]
+			if root, ok := bucket[elasticRootCountName].(map[string]interface{}); ok {
+				row[v.Name()] = root["doc_count"]
+			}
+			continue
+		}
+		// nested 字段的聚合结果在包裹层中
+		agg := bucket
+		for k := 0; agg != nil; k++ {
+			if metric, ok := agg[v.Name()].(map[string]interface{}); ok {
+				row[v.Name()] = metric["value"]
+				break
+			}
+			agg, _ = agg[elasticNestedName(v.Name(), k)].(map[string]interface{})
+		}
+	}
+	return row
+}
+
+// 命中总数，7版本以后为{"value": 1, "relation": "eq"}
+func elasticTotal(resp map[string]interface{}) interface{} {
+	hits, _ := resp["hits"].(map[string]interface{})
+	if total, ok := hits["total"].(map[string]interface{}); ok {
+		return total["value"]
+	}
+	return hits["total"]
+}
+
+// json.Number 转为int64或float64，map和数组直接修改
+func elasticNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if num, err := v.Int64(); err == nil {
+			return num
+		}
+		num, _ := v.Float64()
+		return num
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = elasticNumbers(item)
+		}
+	case []interface{}:
+		for k, item := range v {
+			v[k] = elasticNumbers(item)
+		}
+	}
+	return val
+}
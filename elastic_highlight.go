@@ -0,0 +1,42 @@
+package zql
+
+import (
+	"errors"
+	"strings"
+)
+
+// ElasticHighlightKey 结果中高亮片段的默认列名，可以用 highlight(title) as hl 修改
+const ElasticHighlightKey = "_highlight"
+
+// select 中的 highlight(title, body) 转为highlight，字段为*时高亮全部匹配字段
+func elasticHighlight(field *SelectField, opts *ElasticOptions) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	for _, v := range strings.Split(field.Field, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, errors.New("Field 'select' format error:" + field.Expr)
+		}
+		fields[opts.field(v)] = map[string]interface{}{}
+	}
+	highlight := map[string]interface{}{"fields": fields}
+	if opts.HighlightPreTag != "" {
+		highlight["pre_tags"] = []string{opts.HighlightPreTag}
+	}
+	if opts.HighlightPostTag != "" {
+		highlight["post_tags"] = []string{opts.HighlightPostTag}
+	}
+	if opts.HighlightFragmentSize > 0 {
+		highlight["fragment_size"] = opts.HighlightFragmentSize
+	}
+	return highlight, nil
+}
+
+// 高亮片段在结果中的列名
+func elasticHighlightName(fields []*SelectField) string {
+	for _, v := range fields {
+		if v.Func == "highlight" {
+			return v.Name()
+		}
+	}
+	return ElasticHighlightKey
+}
@@ -0,0 +1,175 @@
+package zql
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// 按时间范围展开的索引数超过此值时使用通配符
+const elasticMaxIndices = 400
+
+// 索引模板中的日期格式转为go格式
+var elasticIndexLayout = strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02", "HH", "15")
+
+// ElasticIndices 要查询的索引列表，未设置IndexPattern时为Prefix+From
+// 设置了模板时根据where中的时间范围展开，例如 {prefix}{from}-{yyyy.MM.dd}，没有开始时间时日期部分使用通配符
+func (zql *Zql) ElasticIndices() ([]string, error) {
+	return zql.elasticIndices(zql.elasticOptions(ElasticVersionDefault))
+}
+
+func (zql *Zql) elasticIndices(opts *ElasticOptions) ([]string, error) {
+	if opts.IndexPattern == "" {
+		return []string{zql.Prefix + zql.From}, nil
+	}
+	// 拆分模板，parts中奇数位置为{}中的内容，dates记录日期部分
+	parts := strings.Split(strings.Replace(opts.IndexPattern, "}", "{", -1), "{")
+	if len(parts)%2 == 0 {
+		return nil, errors.New("Index pattern format error:" + opts.IndexPattern)
+	}
+	dates := make(map[int]bool)
+	layout := ""
+	for k := 1; k < len(parts); k += 2 {
+		switch parts[k] {
+		case "prefix":
+			parts[k] = zql.Prefix
+		case "from":
+			parts[k] = zql.From
+		default:
+			dates[k] = true
+			layout += parts[k]
+		}
+	}
+	wildcard := elasticIndexName(parts, dates, func(string) string { return "*" })
+	if layout == "" {
+		return []string{wildcard}, nil
+	}
+	start, end, ok := zql.elasticTimeRange(opts)
+	if !ok {
+		return []string{wildcard}, nil
+	}
+	loc := opts.IndexLocation
+	if loc == nil {
+		loc = time.UTC
+	}
+	// 按模板中最小的时间单位逐个生成
+	start = start.In(loc)
+	var next func(t time.Time) time.Time
+	switch {
+	case strings.Contains(layout, "HH"):
+		start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, loc)
+		next = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case strings.Contains(layout, "dd"):
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case strings.Contains(layout, "MM"):
+		start = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, loc)
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	default:
+		start = time.Date(start.Year(), 1, 1, 0, 0, 0, 0, loc)
+		next = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	}
+	indices := make([]string, 0)
+	for t := start; !t.After(end); t = next(t) {
+		if len(indices) >= elasticMaxIndices {
+			return []string{wildcard}, nil
+		}
+		indices = append(indices, elasticIndexName(parts, dates, func(format string) string {
+			return t.Format(elasticIndexLayout.Replace(format))
+		}))
+	}
+	return indices, nil
+}
+
+// 拼接索引名，日期部分由format生成
+func elasticIndexName(parts []string, dates map[int]bool, format func(string) string) string {
+	name := ""
+	for k, v := range parts {
+		if dates[k] {
+			v = format(v)
+		}
+		name += v
+	}
+	return name
+}
+
+// where中and连接的时间条件，没有结束时间时为当前时间
+func (zql *Zql) elasticTimeRange(opts *ElasticOptions) (time.Time, time.Time, bool) {
+	var start, end time.Time
+	for _, v := range zql.elasticTimeConditions(opts.TimeField, opts) {
+		val, err := memoryLiteral(v.Value)
+		if err != nil {
+			continue
+		}
+		t, ok := val.(time.Time)
+		if !ok {
+			continue
+		}
+		switch v.Exp {
+		case ">", ">=":
+			start = t
+		case "<", "<=":
+			end = t
+		case "=":
+			start, end = t, t
+		}
+	}
+	if start.IsZero() {
+		return start, end, false
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return start, end, true
+}
+
+// where中and连接的field字段上的条件
+func (zql *Zql) elasticTimeConditions(field string, opts *ElasticOptions) []*WhereNode {
+	conditions := make([]*WhereNode, 0)
+	tree, err := zql.WhereTree()
+	if err != nil || tree == nil {
+		return conditions
+	}
+	nodes := []*WhereNode{tree}
+	if tree.Op == "and" {
+		nodes = tree.Children
+	}
+	for _, v := range nodes {
+		if v.IsLeaf() && opts.field(v.Field) == field {
+			conditions = append(conditions, v)
+		}
+	}
+	return conditions
+}
+
+// 按时间分组的extended_bounds，now()使用elasticsearch的日期表达式，date()使用毫秒时间戳
+// 没有开始时间时返回nil，没有结束时间时为now
+func (zql *Zql) elasticHistogramBounds(field string, opts *ElasticOptions) map[string]interface{} {
+	bounds := make(map[string]interface{})
+	for _, v := range zql.elasticTimeConditions(field, opts) {
+		var val interface{}
+		if strings.HasPrefix(v.Value, "now()") {
+			val, _ = elasticValue(v.Value)
+		} else if t, err := memoryLiteral(v.Value); err == nil {
+			if t, ok := t.(time.Time); ok {
+				val = t.UnixNano() / int64(time.Millisecond)
+			}
+		}
+		if val == nil {
+			continue
+		}
+		switch v.Exp {
+		case ">", ">=":
+			bounds["min"] = val
+		case "<", "<=":
+			bounds["max"] = val
+		}
+	}
+	if _, ok := bounds["min"]; !ok {
+		return nil
+	}
+	if _, ok := bounds["max"]; !ok {
+		bounds["max"] = "now"
+	}
+	return bounds
+}
@@ -0,0 +1,195 @@
+package zql
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// mapping 中的字段信息
+type elasticField struct {
+	typ       string // 字段类型，只有properties时为object
+	nested    string // 所在的nested路径，不在nested中时为空
+	keyword   string // text 字段的keyword子字段，例如name.keyword
+	fielddata bool   // text 字段开启了fielddata，可以直接分组和排序
+}
+
+// FetchElasticMapping 获取索引的mapping，结果可以直接设置到ElasticOptions.Mapping
+func FetchElasticMapping(ctx context.Context, transport esapi.Transport, index string) (map[string]interface{}, error) {
+	return elasticDo(ctx, transport, esapi.IndicesGetMappingRequest{Index: []string{index}})
+}
+
+// 展开mapping中的全部字段，键为a.b.c形式的路径
+// 支持_mapping接口的返回、{"mappings": ...}、6版本以前带type的写法和只有{"properties": ...}的写法
+func elasticMappingFields(mapping map[string]interface{}) map[string]*elasticField {
+	fields := make(map[string]*elasticField)
+	for _, properties := range elasticMappingProperties(mapping) {
+		elasticPropertyFields(properties, "", "", fields)
+	}
+	return fields
+}
+
+// 找到各索引各type的properties
+func elasticMappingProperties(mapping map[string]interface{}) []map[string]interface{} {
+	if properties, ok := mapping["properties"].(map[string]interface{}); ok {
+		return []map[string]interface{}{properties}
+	}
+	if mappings, ok := mapping["mappings"].(map[string]interface{}); ok {
+		return elasticMappingProperties(mappings)
+	}
+	list := make([]map[string]interface{}, 0)
+	for _, v := range mapping {
+		if body, ok := v.(map[string]interface{}); ok {
+			list = append(list, elasticMappingProperties(body)...)
+		}
+	}
+	return list
+}
+
+// 逐层展开properties，nested为当前所在的nested路径
+func elasticPropertyFields(properties map[string]interface{}, prefix, nested string, fields map[string]*elasticField) {
+	for name, v := range properties {
+		body, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := prefix + name
+		field := &elasticField{nested: nested}
+		field.typ, _ = body["type"].(string)
+		if field.typ == "" {
+			field.typ = "object"
+		}
+		field.fielddata, _ = body["fielddata"].(bool)
+		// 子字段中的keyword，优先使用名称为keyword的子字段
+		subFields, _ := body["fields"].(map[string]interface{})
+		for subName, subBody := range subFields {
+			subMap, _ := subBody.(map[string]interface{})
+			if subMap["type"] != "keyword" {
+				continue
+			}
+			if field.keyword == "" || subName == "keyword" {
+				field.keyword = path + "." + subName
+			}
+		}
+		fields[path] = field
+		if sub, ok := body["properties"].(map[string]interface{}); ok {
+			if field.typ == "nested" {
+				elasticPropertyFields(sub, path+".", path, fields)
+			} else {
+				elasticPropertyFields(sub, path+".", nested, fields)
+			}
+		}
+	}
+}
+
+// 字段所在的nested路径，mapping中没有的字段按上级路径判断
+func (opts *ElasticOptions) nestedPath(name string) string {
+	if len(opts.fields) == 0 || name == "" {
+		return ""
+	}
+	name = opts.field(name)
+	if field, ok := opts.fields[name]; ok {
+		return field.nested
+	}
+	for k := strings.LastIndex(name, "."); k > 0; k = strings.LastIndex(name, ".") {
+		name = name[:k]
+		if field, ok := opts.fields[name]; ok {
+			if field.typ == "nested" {
+				return name
+			}
+			return field.nested
+		}
+	}
+	return ""
+}
+
+// 分组和排序使用的字段，text 字段使用keyword子字段，没有可以聚合的字段时返回错误
+func (opts *ElasticOptions) aggField(name, keyword string) (string, error) {
+	name = opts.field(name)
+	field, ok := opts.fields[name]
+	if !ok || field.typ != "text" || field.fielddata {
+		return name, nil
+	}
+	if field.keyword != "" {
+		return field.keyword, nil
+	}
+	return "", errors.New("Field '" + name + "' is text without a keyword sub-field, it cannot be used in '" + keyword + "'")
+}
+
+// 精确匹配使用的字段，text 字段有keyword子字段时返回子字段
+func (opts *ElasticOptions) exactField(name string) (string, bool) {
+	name = opts.field(name)
+	if field, ok := opts.fields[name]; ok && field.typ == "text" && field.keyword != "" {
+		return field.keyword, true
+	}
+	return name, false
+}
+
+// nested 查询，同一路径的多个条件放在一起，要求匹配同一个元素
+func elasticNestedQuery(path string, queries []interface{}) map[string]interface{} {
+	query := queries[0]
+	if len(queries) > 1 {
+		query = map[string]interface{}{"bool": map[string]interface{}{"must": queries}}
+	}
+	return map[string]interface{}{"nested": map[string]interface{}{"path": path, "query": query}}
+}
+
+// 从from所在的nested路径切换到to，返回需要依次嵌套的nested或reverse_nested聚合
+func elasticNestedWraps(from, to string) []map[string]interface{} {
+	if from == to {
+		return nil
+	}
+	wraps := make([]map[string]interface{}, 0, 2)
+	if from != "" && (to == "" || !strings.HasPrefix(to, from+".")) {
+		reverse := map[string]interface{}{}
+		// 回到上级nested路径
+		if to != "" && strings.HasPrefix(from, to+".") {
+			reverse["path"] = to
+			return append(wraps, map[string]interface{}{"reverse_nested": reverse})
+		}
+		wraps = append(wraps, map[string]interface{}{"reverse_nested": reverse})
+	}
+	if to != "" {
+		wraps = append(wraps, map[string]interface{}{"nested": map[string]interface{}{"path": to}})
+	}
+	return wraps
+}
+
+// 用nested聚合包裹agg，包裹层名称为name_nested0、name_nested1...
+func elasticWrapAgg(name string, agg map[string]interface{}, wraps []map[string]interface{}) map[string]interface{} {
+	aggs := map[string]interface{}{name: agg}
+	for k := len(wraps) - 1; k >= 0; k-- {
+		wrap := make(map[string]interface{}, len(wraps[k])+1)
+		for key, val := range wraps[k] {
+			wrap[key] = val
+		}
+		wrap["aggs"] = aggs
+		aggs = map[string]interface{}{elasticNestedName(name, k): wrap}
+	}
+	return aggs
+}
+
+func elasticNestedName(name string, k int) string {
+	return name + "_nested" + strconv.Itoa(k)
+}
+
+// 按包裹层逐层取出聚合结果
+func elasticUnwrapAgg(aggs map[string]interface{}, name string, wraps int) map[string]interface{} {
+	for k := 0; k < wraps; k++ {
+		aggs, _ = aggs[elasticNestedName(name, k)].(map[string]interface{})
+	}
+	agg, _ := aggs[name].(map[string]interface{})
+	return agg
+}
+
+// 排序时引用包裹层中的聚合，a_nested0>a
+func elasticAggPath(name string, wraps int) string {
+	path := ""
+	for k := 0; k < wraps; k++ {
+		path += elasticNestedName(name, k) + ">"
+	}
+	return path + name
+}
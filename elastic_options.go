@@ -0,0 +1,110 @@
+package zql
+
+import (
+	"sync"
+	"time"
+)
+
+// elasticsearch 默认版本，elastic.v3客户端对应2.x，官方客户端默认7.x
+const (
+	ElasticVersionLegacy  = 2
+	ElasticVersionDefault = 7
+)
+
+// ElasticOptions elasticsearch 索引的查询配置
+type ElasticOptions struct {
+	Version   int    // 目标大版本，决定DSL写法，例如7版本以后没有type、使用fixed_interval
+	TimeField string // 时间字段，条件和分组中的time对应此字段，默认date
+	// 分组使用composite聚合，可以通过after游标遍历全部分组，不支持按聚合结果排序
+	// 6.x中分组字段不存在的文档不在结果中
+	Composite bool
+	// 高亮标签和片段长度，为空时使用elasticsearch默认的<em></em>和100
+	HighlightPreTag       string
+	HighlightPostTag      string
+	HighlightFragmentSize int
+	// 索引名模板，例如 {prefix}{from}-{yyyy.MM.dd}，按where中的时间范围查询对应的索引
+	IndexPattern  string
+	IndexLocation *time.Location // 索引名中日期的时区，默认UTC
+	// 按时间分组的输出格式和时区，格式默认yyyy-MM-dd HH:mm:ss，时区默认UTC
+	HistogramFormat   string
+	HistogramTimeZone string
+	// 间隔类型 fixed 或 calendar，为空时年和月使用calendar，可以用 time(1d, type=calendar) 指定
+	HistogramInterval string
+	// 不返回没有数据的时间分组，默认按where中的时间范围返回全部分组
+	HistogramSkipEmpty bool
+	// 索引的mapping，可以通过FetchElasticMapping获取，nested字段上的条件和分组使用nested查询和聚合
+	// 第一次使用时展开并缓存，修改后需要使用新的配置对象
+	Mapping map[string]interface{}
+	fields  map[string]*elasticField // 从Mapping展开的字段
+}
+
+// 按时间分组的间隔类型
+const (
+	ElasticIntervalFixed    = "fixed"
+	ElasticIntervalCalendar = "calendar"
+)
+
+// 默认配置
+var defaultElasticOptions = &ElasticOptions{TimeField: "date", HistogramFormat: "yyyy-MM-dd HH:mm:ss"}
+
+// 按表名保存的配置
+var (
+	elasticOptionsMap  = make(map[string]*ElasticOptions)
+	elasticOptionsLock sync.RWMutex
+)
+
+// SetElasticOptions 设置表的查询配置，tname为Prefix+From
+func SetElasticOptions(tname string, opts *ElasticOptions) {
+	elasticOptionsLock.Lock()
+	defer elasticOptionsLock.Unlock()
+	if opts == nil {
+		delete(elasticOptionsMap, tname)
+		return
+	}
+	// 设置时展开mapping，查询时不再重复展开
+	stored := *opts
+	if stored.Mapping != nil && stored.fields == nil {
+		stored.fields = elasticMappingFields(stored.Mapping)
+	}
+	elasticOptionsMap[tname] = &stored
+}
+
+// 当前查询使用的配置，优先使用zql.Elastic，其次是SetElasticOptions设置的配置，未设置版本时使用version
+func (zql *Zql) elasticOptions(version int) *ElasticOptions {
+	merged := ElasticOptions{}
+	elasticOptionsLock.RLock()
+	opts := zql.Elastic
+	if opts == nil {
+		opts = elasticOptionsMap[zql.Prefix+zql.From]
+	}
+	if opts != nil {
+		merged = *opts
+	}
+	elasticOptionsLock.RUnlock()
+	// 未设置的项使用默认值
+	if merged.Version == 0 {
+		merged.Version = version
+	}
+	if merged.TimeField == "" {
+		merged.TimeField = defaultElasticOptions.TimeField
+	}
+	if merged.HistogramFormat == "" {
+		merged.HistogramFormat = defaultElasticOptions.HistogramFormat
+	}
+	// zql.Elastic 第一次使用时展开mapping并保存
+	if merged.Mapping != nil && merged.fields == nil {
+		merged.fields = elasticMappingFields(merged.Mapping)
+		elasticOptionsLock.Lock()
+		opts.fields = merged.fields
+		elasticOptionsLock.Unlock()
+	}
+	return &merged
+}
+
+// 条件和分组中的time对应时间字段
+func (opts *ElasticOptions) field(name string) string {
+	if name == "time" {
+		return opts.TimeField
+	}
+	return name
+}
@@ -0,0 +1,166 @@
+package zql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// 命中总数的关系，超过track_total_hits时为gte
+const (
+	ElasticRelationEq  = "eq"
+	ElasticRelationGte = "gte"
+)
+
+// ElasticResult 查询结果和命中总数、耗时、分片等信息，分页时不需要再执行count查询
+type ElasticResult struct {
+	Rows          []map[string]interface{}
+	Total         int64  // 命中总数
+	TotalRelation string // eq 或 gte，gte时Total为下限
+	Took          int64  // 耗时，毫秒
+	TimedOut      bool
+	Shards        ElasticShards
+	// terms 分组未返回的文档数，键为分组名称，多层分组时为各父分组之和
+	OtherDocCount map[string]int64
+	// composite 聚合下一页的游标，设置到After后查询下一页，最后一页时为空
+	Cursor string
+}
+
+// ElasticShards 分片执行情况
+type ElasticShards struct {
+	Total      int64
+	Successful int64
+	Skipped    int64
+	Failed     int64
+	Failures   []ElasticShardFailure
+}
+
+// ElasticShardFailure 单个分片的失败信息
+type ElasticShardFailure struct {
+	Index  string
+	Shard  int64
+	Node   string
+	Reason string
+}
+
+// GetElasticResult 使用elastic.v3客户端执行ElasticDSL生成的查询，返回结果和元信息
+// 查询写法和结果格式与GetElasticQuery不同，未配置版本时使用2.x写法
+func (zql *Zql) GetElasticResult(client *elastic.Client, dbName string, pretty bool) (*ElasticResult, error) {
+	opts := zql.elasticOptions(ElasticVersionLegacy)
+	resp, err := zql.elasticV3Search(client, dbName, pretty, opts)
+	if err != nil {
+		return nil, err
+	}
+	return zql.elasticResult(resp, opts)
+}
+
+// GetElasticsearchResult 使用官方客户端执行查询，返回结果和元信息
+func (zql *Zql) GetElasticsearchResult(ctx context.Context, transport esapi.Transport, index string) (*ElasticResult, error) {
+	opts := zql.elasticOptions(ElasticVersionDefault)
+	dsl, err := zql.elasticDSL(opts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := zql.elasticSearch(ctx, transport, index, dsl)
+	if err != nil {
+		return nil, err
+	}
+	return zql.elasticResult(resp, opts)
+}
+
+// 解析结果，composite 聚合时返回下一页游标
+func (zql *Zql) elasticResult(resp map[string]interface{}, opts *ElasticOptions) (*ElasticResult, error) {
+	var err error
+	result := &ElasticResult{TotalRelation: ElasticRelationEq}
+	if zql.isElasticComposite(opts) {
+		if result.Cursor, err = zql.elasticCompositeCursor(resp); err != nil {
+			return nil, err
+		}
+	}
+	// 转换数字后读取元信息
+	if result.Rows, err = zql.elasticRows(resp, opts); err != nil {
+		return nil, err
+	}
+	hits, _ := resp["hits"].(map[string]interface{})
+	if total, ok := hits["total"].(map[string]interface{}); ok {
+		result.Total = elasticInt(total["value"])
+		if relation, ok := total["relation"].(string); ok {
+			result.TotalRelation = relation
+		}
+	} else {
+		result.Total = elasticInt(hits["total"])
+	}
+	result.Took = elasticInt(resp["took"])
+	result.TimedOut, _ = resp["timed_out"].(bool)
+	if shards, ok := resp["_shards"].(map[string]interface{}); ok {
+		result.Shards = elasticShards(shards)
+	}
+	if aggs, ok := resp["aggregations"].(map[string]interface{}); ok {
+		result.OtherDocCount = make(map[string]int64)
+		elasticOtherDocCount(zql.elasticAggLevels(opts), aggs, result.OtherDocCount)
+	}
+	return result, nil
+}
+
+// 分片信息和失败原因
+func elasticShards(shards map[string]interface{}) ElasticShards {
+	info := ElasticShards{
+		Total:      elasticInt(shards["total"]),
+		Successful: elasticInt(shards["successful"]),
+		Skipped:    elasticInt(shards["skipped"]),
+		Failed:     elasticInt(shards["failed"]),
+	}
+	failures, _ := shards["failures"].([]interface{})
+	for _, v := range failures {
+		failure, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		item := ElasticShardFailure{Shard: elasticInt(failure["shard"])}
+		item.Index, _ = failure["index"].(string)
+		item.Node, _ = failure["node"].(string)
+		if reason, ok := failure["reason"].(map[string]interface{}); ok {
+			item.Reason, _ = reason["reason"].(string)
+		} else if reason, ok := failure["reason"].(string); ok {
+			item.Reason = reason
+		}
+		info.Failures = append(info.Failures, item)
+	}
+	return info
+}
+
+// 逐层累加terms分组的sum_other_doc_count
+func elasticOtherDocCount(levels []*elasticAggLevel, aggs map[string]interface{}, counts map[string]int64) {
+	if len(levels) == 0 {
+		return
+	}
+	agg := elasticUnwrapAgg(aggs, levels[0].name, len(levels[0].wraps))
+	if count, ok := agg["sum_other_doc_count"]; ok {
+		counts[levels[0].name] += elasticInt(count)
+	}
+	buckets, _ := agg["buckets"].([]interface{})
+	for _, v := range buckets {
+		if bucket, ok := v.(map[string]interface{}); ok {
+			elasticOtherDocCount(levels[1:], bucket, counts)
+		}
+	}
+}
+
+// 数字转int64，elasticRows已将json.Number转换为int64或float64
+func elasticInt(val interface{}) int64 {
+	switch v := val.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case int:
+		return int64(v)
+	case nil:
+		return 0
+	}
+	var num int64
+	fmt.Sscan(fmt.Sprint(val), &num)
+	return num
+}
@@ -1,9 +1,11 @@
 package zql
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -88,6 +90,50 @@ func (zql *Zql) GetElasticQueryStr() (string, error) {
 	return string(js), nil
 }
 
+// 使用elastic.v3客户端执行ElasticDSL生成的查询，返回解析后的结果，供GetElasticResult使用
+// 直接解析响应内容，SearchResult中没有分片失败信息
+func (zql *Zql) elasticV3Search(client *elastic.Client, dbName string, pretty bool, opts *ElasticOptions) (map[string]interface{}, error) {
+	dsl, err := zql.elasticDSL(opts)
+	if err != nil {
+		return nil, err
+	}
+	// dbName 为空时按索引模板查询
+	indices := []string{dbName}
+	if dbName == "" {
+		if indices, err = zql.elasticIndices(opts); err != nil {
+			return nil, err
+		}
+	}
+	path := "/" + url.PathEscape(strings.Join(indices, ","))
+	// 7版本以后没有type
+	if opts.Version < 7 {
+		path += "/" + url.PathEscape(zql.Prefix+zql.From) // 表名
+	}
+	params := url.Values{}
+	if pretty {
+		params.Set("pretty", "true") // 美化输出
+	}
+	if opts.IndexPattern != "" && dbName == "" {
+		params.Set("ignore_unavailable", "true")
+	}
+	res, err := client.PerformRequest("POST", path+"/_search", params, dsl) // 执行
+	if err != nil {
+		return nil, err
+	}
+	// 转为通用结构后生成类似数据库数组数据
+	resp := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(res.Body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&resp); err != nil {
+		return nil, errors.New("Analytical result set 'json' error")
+	}
+	// 执行查询出错时
+	if _, ok := resp["error"]; ok {
+		return nil, elasticError(resp, strconv.Itoa(res.StatusCode))
+	}
+	return resp, nil
+}
+
 // 组织整个查询信息
 func (zql *Zql) GetElasticSearchSource() (*elastic.SearchSource, error) {
 	// 查询构造对象
@@ -0,0 +1,405 @@
+package zql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 输出方言
+const (
+	dialectEsSql = "essql" // Elasticsearch SQL
+	dialectPpl   = "ppl"   // OpenSearch PPL
+)
+
+// 时间单位对应的sql interval关键词，周和季度转为天和月
+var sqlIntervalUnits = map[string]string{
+	"y":  "YEAR",
+	"mo": "MONTH",
+	"d":  "DAY",
+	"h":  "HOUR",
+	"m":  "MINUTE",
+	"s":  "SECOND",
+}
+
+// 不需要引号的字段名
+var plainIdentReg = regexp.MustCompile(`^[a-z_][a-z0-9_.]*$`)
+
+// GetElasticSqlStr 生成Elasticsearch SQL语句，用于调试和在kibana中执行
+func (zql *Zql) GetElasticSqlStr() (string, error) {
+	fields, err := zql.sqlSelectFields(dialectEsSql)
+	if err != nil {
+		return "", err
+	}
+	groupFields, interval := zql.ParseGroupBy()
+	selectList := make([]string, 0)
+	groupList := make([]string, 0)
+	if interval != "" {
+		histogram, err := sqlHistogram(zql.elasticTimeGroupField(zql.elasticOptions(ElasticVersionDefault)), interval)
+		if err != nil {
+			return "", err
+		}
+		selectList = append(selectList, histogram+" AS "+quoteIdent("time", dialectEsSql))
+		groupList = append(groupList, histogram)
+	}
+	for _, v := range groupFields {
+		groupList = append(groupList, quoteIdent(v, dialectEsSql))
+	}
+	for _, v := range fields {
+		selectList = append(selectList, sqlSelectExpr(v, dialectEsSql))
+	}
+	query := "SELECT " + strings.Join(selectList, ", ") + " FROM " + quoteName(zql.Prefix+zql.From, dialectEsSql)
+	// where
+	if zql.Where != "" {
+		where, err := zql.whereToSql(dialectEsSql)
+		if err != nil {
+			return "", err
+		}
+		query += " WHERE " + where
+	}
+	// group by
+	if len(groupList) > 0 {
+		query += " GROUP BY " + strings.Join(groupList, ", ")
+	}
+	// order by
+	orders := zql.ParseOrderBy()
+	if len(orders) > 0 {
+		orderList := make([]string, 0)
+		for _, v := range orders {
+			sc := "ASC"
+			if v.Desc {
+				sc = "DESC"
+			}
+			orderList = append(orderList, quoteIdent(v.Field, dialectEsSql)+" "+sc)
+		}
+		query += " ORDER BY " + strings.Join(orderList, ", ")
+	}
+	// limit Elasticsearch SQL 不支持 offset
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return "", err
+	}
+	if skip > 0 {
+		return "", errors.New("Elasticsearch SQL does not support 'limit' offset")
+	}
+	if limit >= 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return query, nil
+}
+
+// GetOpenSearchPplStr 生成OpenSearch PPL语句，用于调试和在OpenSearch Dashboards中执行
+func (zql *Zql) GetOpenSearchPplStr() (string, error) {
+	fields, err := zql.sqlSelectFields(dialectPpl)
+	if err != nil {
+		return "", err
+	}
+	commands := []string{"source=" + quoteName(zql.Prefix+zql.From, dialectPpl)}
+	// where
+	if zql.Where != "" {
+		where, err := zql.whereToSql(dialectPpl)
+		if err != nil {
+			return "", err
+		}
+		commands = append(commands, "where "+where)
+	}
+	groupFields, interval := zql.ParseGroupBy()
+	if zql.GroupBy != "" || fields[0].Func != "" {
+		// 聚合查询使用stats
+		aggList := make([]string, 0)
+		for _, v := range fields {
+			if v.Func != "" {
+				aggList = append(aggList, sqlSelectExpr(v, dialectPpl))
+			}
+		}
+		byList := make([]string, 0)
+		if interval != "" {
+			num, unit, err := splitInterval(interval)
+			if err != nil {
+				return "", err
+			}
+			span := fmt.Sprintf("%d%s", num, elasticIntervalUnits[unit])
+			timeField := zql.elasticTimeGroupField(zql.elasticOptions(ElasticVersionDefault))
+			byList = append(byList, "span("+quoteIdent(timeField, dialectPpl)+", "+span+") as "+quoteIdent("time", dialectPpl))
+		}
+		for _, v := range groupFields {
+			byList = append(byList, quoteIdent(v, dialectPpl))
+		}
+		stats := "stats " + strings.Join(aggList, ", ")
+		if len(byList) > 0 {
+			stats += " by " + strings.Join(byList, ", ")
+		}
+		commands = append(commands, stats)
+	}
+	// order by
+	orders := zql.ParseOrderBy()
+	if len(orders) > 0 {
+		orderList := make([]string, 0)
+		for _, v := range orders {
+			sc := "+ "
+			if v.Desc {
+				sc = "- "
+			}
+			orderList = append(orderList, sc+quoteIdent(v.Field, dialectPpl))
+		}
+		commands = append(commands, "sort "+strings.Join(orderList, ", "))
+	}
+	// limit
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return "", err
+	}
+	if limit >= 0 {
+		head := fmt.Sprintf("head %d", limit)
+		if skip > 0 {
+			head += fmt.Sprintf(" from %d", skip)
+		}
+		commands = append(commands, head)
+	}
+	// 非聚合查询的字段列表和别名
+	if zql.GroupBy == "" && fields[0].Func == "" && zql.Select != "*" {
+		fieldList := make([]string, 0)
+		renameList := make([]string, 0)
+		for _, v := range fields {
+			fieldList = append(fieldList, quoteIdent(v.Field, dialectPpl))
+			if v.Alias != "" {
+				renameList = append(renameList, quoteIdent(v.Field, dialectPpl)+" as "+quoteIdent(v.Alias, dialectPpl))
+			}
+		}
+		commands = append(commands, "fields "+strings.Join(fieldList, ", "))
+		if len(renameList) > 0 {
+			commands = append(commands, "rename "+strings.Join(renameList, ", "))
+		}
+	}
+	return strings.Join(commands, " | "), nil
+}
+
+// 检查select字段是否可以转换
+func (zql *Zql) sqlSelectFields(dialect string) ([]*SelectField, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, errors.New("Query string does not exist 'select|from'")
+	}
+	if fill := zql.ParseFill(); fill != "" && fill != "none" {
+		return nil, fmt.Errorf("Query keywords 'fill(%s)' is not supported by %s", fill, dialectName(dialect))
+	}
+	fields, err := zql.ParseSelect()
+	if err != nil {
+		return nil, err
+	}
+	groupFields, _ := zql.ParseGroupBy()
+	isAggr := zql.GroupBy != ""
+	for _, v := range fields {
+		if v.Func != "" {
+			isAggr = true
+		}
+	}
+	for _, v := range fields {
+		switch v.Func {
+		case "":
+			if !isAggr {
+				continue
+			}
+			if v.Field == "*" {
+				return nil, errors.New("'group by' query field can not be '*'")
+			}
+			// 聚合查询中普通字段只能是分组字段
+			inGroup := false
+			for _, g := range groupFields {
+				if g == v.Field {
+					inGroup = true
+				}
+			}
+			if !inGroup {
+				return nil, errors.New("Field '" + v.Field + "' must be an aggregate or appear in 'group by'")
+			}
+		case "count", "avg", "sum", "max", "min":
+			if v.Field == "" {
+				return nil, errors.New("Field 'select' format error:" + v.Expr)
+			}
+		default:
+			return nil, fmt.Errorf("Function '%s' is not supported by %s", v.Func, dialectName(dialect))
+		}
+	}
+	return fields, nil
+}
+
+// 单个select字段
+func sqlSelectExpr(field *SelectField, dialect string) string {
+	expr := quoteIdent(field.Field, dialect)
+	if field.Field == "*" {
+		expr = "*"
+	}
+	if field.Func != "" {
+		if field.Field == "*" && dialect == dialectPpl {
+			expr = ""
+		}
+		fn := field.Func
+		if dialect == dialectEsSql {
+			fn = strings.ToUpper(fn)
+		}
+		expr = fn + "(" + expr + ")"
+	}
+	if dialect == dialectPpl && field.Func == "" {
+		return expr
+	}
+	name := field.Alias
+	if name == "" && field.Func != "" {
+		name = field.Name()
+	}
+	if name == "" {
+		return expr
+	}
+	as := " AS "
+	if dialect == dialectPpl {
+		as = " as "
+	}
+	return expr + as + quoteIdent(name, dialect)
+}
+
+// 按时间分组 HISTOGRAM("date", INTERVAL 5 MINUTE)
+func sqlHistogram(timeField, interval string) (string, error) {
+	num, unit, err := splitInterval(interval)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("HISTOGRAM(%s, INTERVAL %s)", quoteIdent(timeField, dialectEsSql), sqlInterval(num, unit)), nil
+}
+
+// sql interval 5 MINUTE
+func sqlInterval(num int, unit string) string {
+	switch unit {
+	case "w":
+		num, unit = num*7, "d"
+	case "q":
+		num, unit = num*3, "mo"
+	}
+	return fmt.Sprintf("%d %s", num, sqlIntervalUnits[unit])
+}
+
+// where条件转换
+func (zql *Zql) whereToSql(dialect string) (string, error) {
+	tree, err := zql.WhereTree()
+	if err != nil {
+		return "", err
+	}
+	return whereNodeToSql(tree, dialect, zql.elasticOptions(ElasticVersionDefault).TimeField, true)
+}
+
+func whereNodeToSql(node *WhereNode, dialect, timeField string, top bool) (string, error) {
+	if node.IsLeaf() {
+		return whereLeafToSql(node, dialect, timeField)
+	}
+	list := make([]string, 0)
+	for _, v := range node.Children {
+		str, err := whereNodeToSql(v, dialect, timeField, false)
+		if err != nil {
+			return "", err
+		}
+		list = append(list, str)
+	}
+	str := strings.Join(list, " "+strings.ToUpper(node.Op)+" ")
+	if dialect == dialectPpl {
+		str = strings.Join(list, " "+node.Op+" ")
+	}
+	if top {
+		return str, nil
+	}
+	return "(" + str + ")", nil
+}
+
+// 单个条件
+func whereLeafToSql(node *WhereNode, dialect, timeField string) (string, error) {
+	field := node.Field
+	val, isTime, err := sqlValue(node.Value, dialect)
+	if err != nil {
+		return "", err
+	}
+	// 与elasticsearch查询一致，时间比较的time字段对应配置的时间字段
+	if isTime && field == "time" {
+		field = timeField
+	}
+	field = quoteIdent(field, dialect)
+	switch node.Exp {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return field + " " + node.Exp + " " + val, nil
+	case "in":
+		if node.Value[:1] != "(" || node.Value[len(node.Value)-1:] != ")" {
+			return "", errors.New("Single condition error:" + node.Value)
+		}
+		inList := make([]string, 0)
+		for _, v := range splitTopLevel(node.Value[1:len(node.Value)-1], ',') {
+			inVal, _, err := sqlValue(strings.TrimSpace(v), dialect)
+			if err != nil {
+				return "", err
+			}
+			inList = append(inList, inVal)
+		}
+		if dialect == dialectPpl {
+			return field + " in (" + strings.Join(inList, ", ") + ")", nil
+		}
+		return field + " IN (" + strings.Join(inList, ", ") + ")", nil
+	case "like":
+		if dialect == dialectPpl {
+			return "like(" + field + ", " + val + ")", nil
+		}
+		return field + " LIKE " + val, nil
+	}
+	return "", fmt.Errorf("Operator '%s' is not supported by %s", node.Exp, dialectName(dialect))
+}
+
+// 条件值转换，返回是否是时间值
+func sqlValue(val, dialect string) (string, bool, error) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return "", false, errors.New("Single condition value is empty")
+	}
+	// 相对当前时间 now()-1h
+	isDateArr := strings.Split(val, "-")
+	if strings.TrimSpace(isDateArr[0]) == "now()" {
+		if len(isDateArr) == 1 {
+			return "NOW()", true, nil
+		}
+		num, unit, err := splitInterval(isDateArr[1])
+		if err != nil || len(isDateArr) != 2 {
+			return "", false, errors.New("Time expression error:" + val)
+		}
+		if dialect == dialectPpl {
+			return "DATE_SUB(NOW(), INTERVAL " + sqlInterval(num, unit) + ")", true, nil
+		}
+		return "NOW() - INTERVAL " + sqlInterval(num, unit), true, nil
+	}
+	// 指定时间 date('2017-01-01 00:00:00')
+	if strings.Index(val, "date(") == 0 && val[len(val)-1:] == ")" {
+		dateStr := strings.Trim(strings.TrimSpace(val[5:len(val)-1]), "'")
+		if dialect == dialectPpl {
+			return "TIMESTAMP('" + dateStr + "')", true, nil
+		}
+		return "CAST('" + strings.Replace(dateStr, " ", "T", 1) + "' AS DATETIME)", true, nil
+	}
+	return val, false, nil
+}
+
+// 字段名需要时加引号
+func quoteIdent(name, dialect string) string {
+	if plainIdentReg.MatchString(name) {
+		return name
+	}
+	return quoteName(name, dialect)
+}
+
+// 名称加引号，sql使用双引号，ppl使用反引号
+func quoteName(name, dialect string) string {
+	quote := `"`
+	if dialect == dialectPpl {
+		quote = "`"
+	}
+	return quote + strings.Replace(name, quote, quote+quote, -1) + quote
+}
+
+func dialectName(dialect string) string {
+	if dialect == dialectPpl {
+		return "OpenSearch PPL"
+	}
+	return "Elasticsearch SQL"
+}
@@ -1,9 +1,15 @@
 package zql
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // GetInfluxdbQuery 获得转换后的查询语句
@@ -64,3 +70,200 @@ func InfluxdbWhereLike(str string) string {
 
 	return InfluxdbWhereLike(str)
 }
+
+// InfluxdbConfig influxdb http接口连接信息
+type InfluxdbConfig struct {
+	Addr      string // http://127.0.0.1:8086
+	Database  string // 数据库名
+	Username  string
+	Password  string
+	Epoch     string // 返回时间的精度 h|m|s|ms|u|ns，time列都转为time.Time
+	ChunkSize int    // 大于0时分块返回
+}
+
+// influxdb /query 返回结构
+type influxdbResponse struct {
+	Results []influxdbResult `json:"results"`
+	Err     string           `json:"error"`
+}
+
+type influxdbResult struct {
+	StatementId int              `json:"statement_id"`
+	Series      []influxdbSeries `json:"series"`
+	Err         string           `json:"error"`
+}
+
+type influxdbSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]interface{}   `json:"values"`
+	Partial bool              `json:"partial"`
+}
+
+// ExecInfluxdb 执行查询并返回与GetElasticQuery一致的数据格式，每行包含tags和columns
+func (zql *Zql) ExecInfluxdb(client *http.Client, conf *InfluxdbConfig, suffix string) ([]map[string]interface{}, error) {
+	resultList := make([]map[string]interface{}, 0)
+	reader, err := zql.queryInfluxdb(client, conf, suffix)
+	if err != nil {
+		return resultList, err
+	}
+	defer reader.close()
+	for {
+		row, err := reader.next()
+		if err != nil {
+			return resultList, err
+		}
+		if row == nil {
+			return resultList, nil
+		}
+		resultList = append(resultList, row)
+	}
+}
+
+// influxdb 查询结果读取，分块返回时逐块解析
+type influxdbReader struct {
+	resp    *http.Response
+	decoder *json.Decoder
+	epoch   string
+	rows    []map[string]interface{} // 当前块中未读取的数据
+}
+
+// 发送查询请求
+func (zql *Zql) queryInfluxdb(client *http.Client, conf *InfluxdbConfig, suffix string) (*influxdbReader, error) {
+	if conf == nil || conf.Addr == "" {
+		return nil, errors.New("Influxdb address cannot be empty")
+	}
+	query, err := zql.GetInfluxdbQuery(suffix)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	params := url.Values{}
+	params.Set("q", query)
+	if conf.Database != "" {
+		params.Set("db", conf.Database)
+	}
+	if conf.Epoch != "" {
+		params.Set("epoch", conf.Epoch)
+	}
+	if conf.ChunkSize > 0 {
+		params.Set("chunked", "true")
+		params.Set("chunk_size", strconv.Itoa(conf.ChunkSize))
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(conf.Addr, "/")+"/query?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Username != "" {
+		req.SetBasicAuth(conf.Username, conf.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// 分块返回时为多个连续的json对象
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return &influxdbReader{resp: resp, decoder: decoder, epoch: conf.Epoch}, nil
+}
+
+// 读取下一行，没有数据时返回nil
+func (reader *influxdbReader) next() (map[string]interface{}, error) {
+	for len(reader.rows) == 0 {
+		response := new(influxdbResponse)
+		err := reader.decoder.Decode(response)
+		if err == io.EOF {
+			if reader.resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("Influxdb response status %d", reader.resp.StatusCode)
+			}
+			return nil, nil
+		}
+		if err != nil {
+			if reader.resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("Influxdb response status %d", reader.resp.StatusCode)
+			}
+			return nil, errors.New("Analytical result set 'json' error:" + err.Error())
+		}
+		if response.Err != "" {
+			return nil, errors.New(response.Err)
+		}
+		for _, result := range response.Results {
+			if result.Err != "" {
+				return nil, fmt.Errorf("Influxdb statement %d error: %s", result.StatementId, result.Err)
+			}
+			for _, series := range result.Series {
+				for _, values := range series.Values {
+					reader.rows = append(reader.rows, influxdbRow(series, values, reader.epoch))
+				}
+			}
+		}
+	}
+	row := reader.rows[0]
+	reader.rows = reader.rows[1:]
+	return row, nil
+}
+
+func (reader *influxdbReader) close() error {
+	return reader.resp.Body.Close()
+}
+
+// 展开单行数据，tags和列名合并为一个map
+func influxdbRow(series influxdbSeries, values []interface{}, epoch string) map[string]interface{} {
+	rowMap := make(map[string]interface{}, len(series.Tags)+len(series.Columns))
+	for key, val := range series.Tags {
+		rowMap[key] = val
+	}
+	for k, column := range series.Columns {
+		if k >= len(values) {
+			break
+		}
+		val := values[k]
+		// 时间为RFC3339字符串或epoch精度的时间戳，统一转为time.Time
+		if column == "time" {
+			if t, ok := influxdbTime(val, epoch); ok {
+				rowMap[column] = t
+				continue
+			}
+		}
+		// 数字转为int64或float64
+		if num, ok := val.(json.Number); ok {
+			if i, err := num.Int64(); err == nil {
+				val = i
+			} else if f, err := num.Float64(); err == nil {
+				val = f
+			}
+		}
+		rowMap[column] = val
+	}
+	return rowMap
+}
+
+// epoch 精度对应的纳秒数
+var influxdbEpochs = map[string]int64{
+	"h":  int64(time.Hour),
+	"m":  int64(time.Minute),
+	"s":  int64(time.Second),
+	"ms": int64(time.Millisecond),
+	"u":  int64(time.Microsecond),
+	"ns": 1,
+}
+
+// 解析time列
+func influxdbTime(val interface{}, epoch string) (time.Time, bool) {
+	switch v := val.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		num, err := v.Int64()
+		unit, ok := influxdbEpochs[epoch]
+		if err != nil || !ok {
+			return time.Time{}, false
+		}
+		return time.Unix(0, num*unit), true
+	}
+	return time.Time{}, false
+}
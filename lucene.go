@@ -0,0 +1,225 @@
+package zql
+
+import (
+	"errors"
+	"strings"
+)
+
+// lucene 中需要转义的字符
+const luceneSpecialChars = `+-!(){}[]^"~*?:\/&| `
+
+// WhereToLucene 将where条件转为lucene查询语法，用于kibana、graylog、solr等工具
+func (zql *Zql) WhereToLucene() (string, error) {
+	tree, err := zql.WhereTree()
+	if err != nil {
+		return "", err
+	}
+	if tree == nil {
+		return "*:*", nil
+	}
+	return luceneNode(tree, zql.elasticOptions(ElasticVersionDefault).TimeField, true)
+}
+
+// 条件树节点转换，top为最外层时不加括号
+func luceneNode(node *WhereNode, timeField string, top bool) (string, error) {
+	if node.IsLeaf() {
+		str, negative, err := luceneLeaf(node, timeField)
+		if err != nil {
+			return "", err
+		}
+		// 只有否定条件时lucene匹配不到数据，需要加上全部文档
+		if negative {
+			return "(*:* " + str + ")", nil
+		}
+		return str, nil
+	}
+	list := make([]string, 0)
+	hasPositive := false
+	// and 条件中同一字段的上下限合并为一个范围
+	ranges := make(map[string]*luceneRange)
+	for _, v := range node.Children {
+		if node.Op == "and" && v.IsLeaf() && isRangeExp(v.Exp) {
+			field, val, err := luceneField(v, timeField)
+			if err != nil {
+				return "", err
+			}
+			r, ok := ranges[field]
+			if !ok || !r.set(v.Exp, val) {
+				r = &luceneRange{field: field, index: len(list)}
+				r.set(v.Exp, val)
+				ranges[field] = r
+				list = append(list, "")
+			}
+			list[r.index] = r.String()
+			hasPositive = true
+			continue
+		}
+		if v.IsLeaf() && node.Op == "and" {
+			str, negative, err := luceneLeaf(v, timeField)
+			if err != nil {
+				return "", err
+			}
+			hasPositive = hasPositive || !negative
+			list = append(list, str)
+			continue
+		}
+		str, err := luceneNode(v, timeField, false)
+		if err != nil {
+			return "", err
+		}
+		hasPositive = true
+		list = append(list, str)
+	}
+	// and 条件全部是否定条件
+	if !hasPositive {
+		list = append([]string{"*:*"}, list...)
+	}
+	str := strings.Join(list, " "+strings.ToUpper(node.Op)+" ")
+	if !hasPositive {
+		str = strings.Join(list, " ")
+	}
+	if top {
+		return str, nil
+	}
+	return "(" + str + ")", nil
+}
+
+// 单个条件，返回是否是否定条件
+func luceneLeaf(node *WhereNode, timeField string) (string, bool, error) {
+	field, val, err := luceneField(node, timeField)
+	if err != nil {
+		return "", false, err
+	}
+	switch node.Exp {
+	case "=":
+		return field + ":" + val, false, nil
+	case "!=":
+		return "-" + field + ":" + val, true, nil
+	case "<", "<=", ">", ">=":
+		r := &luceneRange{field: field}
+		r.set(node.Exp, val)
+		return r.String(), false, nil
+	case "in":
+		if node.Value[:1] != "(" || node.Value[len(node.Value)-1:] != ")" {
+			return "", false, errors.New("Single condition error:" + node.Value)
+		}
+		inList := make([]string, 0)
+		for _, v := range splitTopLevel(node.Value[1:len(node.Value)-1], ',') {
+			inList = append(inList, luceneValue(strings.TrimSpace(v)))
+		}
+		return field + ":(" + strings.Join(inList, " OR ") + ")", false, nil
+	case "like":
+		return field + ":" + luceneWildcard(strings.Trim(node.Value, "'")), false, nil
+	}
+	return "", false, errors.New("Operator '" + node.Exp + "' is not supported by lucene")
+}
+
+// 字段名和值，时间比较的time字段对应配置的时间字段
+func luceneField(node *WhereNode, timeField string) (string, string, error) {
+	field := node.Field
+	val := strings.TrimSpace(node.Value)
+	isDateArr := strings.Split(val, "-")
+	if strings.TrimSpace(isDateArr[0]) == "now()" {
+		// 相对时间使用lucene日期运算 now-1h
+		var err error
+		if val, err = elasticDateMath(val); err != nil {
+			return "", "", err
+		}
+		if field == "time" {
+			field = timeField
+		}
+	} else if strings.Index(val, "date(") == 0 && val[len(val)-1:] == ")" {
+		dateStr := strings.Trim(strings.TrimSpace(val[5:len(val)-1]), "'")
+		val = `"` + strings.Replace(dateStr, " ", "T", 1) + `"`
+		if field == "time" {
+			field = timeField
+		}
+	} else if node.Exp != "like" && node.Exp != "in" {
+		val = luceneValue(val)
+	}
+	return luceneEscape(field), val, nil
+}
+
+// 值转换，字符串使用短语查询
+func luceneValue(val string) string {
+	if len(val) >= 2 && val[:1] == "'" && val[len(val)-1:] == "'" {
+		val = val[1 : len(val)-1]
+		return `"` + strings.Replace(strings.Replace(val, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+	}
+	return luceneEscape(val)
+}
+
+// like 转为通配符，% 匹配任意字符，_ 匹配单个字符，原有的*?转义
+func luceneWildcard(val string) string {
+	str := ""
+	for _, v := range val {
+		switch v {
+		case '%':
+			str += "*"
+		case '_':
+			str += "?"
+		default:
+			str += luceneEscape(string(v))
+		}
+	}
+	return str
+}
+
+// 转义lucene特殊字符
+func luceneEscape(str string) string {
+	escaped := ""
+	for _, v := range str {
+		if strings.ContainsRune(luceneSpecialChars, v) {
+			escaped += `\`
+		}
+		escaped += string(v)
+	}
+	return escaped
+}
+
+func isRangeExp(exp string) bool {
+	return exp == "<" || exp == "<=" || exp == ">" || exp == ">="
+}
+
+// lucene 范围查询 field:[a TO b]
+type luceneRange struct {
+	field      string
+	index      int // 在条件列表中的位置
+	lower      string
+	upper      string
+	lowerEqual bool
+	upperEqual bool
+}
+
+// 设置上限或下限，已经设置过时返回false
+func (r *luceneRange) set(exp, val string) bool {
+	switch exp {
+	case ">", ">=":
+		if r.lower != "" {
+			return false
+		}
+		r.lower, r.lowerEqual = val, exp == ">="
+	case "<", "<=":
+		if r.upper != "" {
+			return false
+		}
+		r.upper, r.upperEqual = val, exp == "<="
+	}
+	return true
+}
+
+func (r *luceneRange) String() string {
+	lower, upper := r.lower, r.upper
+	start, end := "{", "}"
+	if lower == "" {
+		lower, start = "*", "["
+	} else if r.lowerEqual {
+		start = "["
+	}
+	if upper == "" {
+		upper, end = "*", "]"
+	} else if r.upperEqual {
+		end = "]"
+	}
+	return r.field + ":" + start + lower + " TO " + upper + end
+}
@@ -0,0 +1,556 @@
+package zql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecMemory 在内存数据上执行查询，用于测试和查询进程内缓存
+// 条件中的字段直接对应数据中的key，支持a.b.c访问嵌套map，time(5m)分组使用time字段
+func (zql *Zql) ExecMemory(rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, errors.New("Query string does not exist 'select|from'")
+	}
+	fields, err := zql.ParseSelect()
+	if err != nil {
+		return nil, err
+	}
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return nil, err
+	}
+	// where
+	tree, err := zql.WhereTree()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, 0)
+	for _, row := range rows {
+		ok, err := memoryMatch(tree, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			list = append(list, row)
+		}
+	}
+	isAggr := zql.GroupBy != ""
+	for _, v := range fields {
+		if v.Func != "" {
+			isAggr = true
+		}
+	}
+	orders := zql.ParseOrderBy()
+	if isAggr {
+		// 分组聚合后按结果列排序
+		list, err = zql.memoryGroup(list, fields)
+		if err != nil {
+			return nil, err
+		}
+		memorySort(list, orders, nil)
+	} else {
+		// 先按原字段排序再投影，排序字段可以是别名
+		alias := make(map[string]string)
+		for _, v := range fields {
+			if v.Alias != "" {
+				alias[v.Alias] = v.Field
+			}
+		}
+		memorySort(list, orders, alias)
+	}
+	// limit
+	if skip > len(list) {
+		skip = len(list)
+	}
+	list = list[skip:]
+	if limit >= 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	if isAggr {
+		return list, nil
+	}
+	// 查询字段列表
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, row := range list {
+		rowMap := make(map[string]interface{})
+		for _, v := range fields {
+			if v.Field == "*" {
+				for key, val := range row {
+					rowMap[key] = val
+				}
+				continue
+			}
+			if val, ok := memoryValue(row, v.Field); ok {
+				rowMap[v.Name()] = val
+			}
+		}
+		result = append(result, rowMap)
+	}
+	return result, nil
+}
+
+// 分组聚合
+func (zql *Zql) memoryGroup(rows []map[string]interface{}, fields []*SelectField) ([]map[string]interface{}, error) {
+	groupFields, interval := zql.ParseGroupBy()
+	if interval != "" {
+		if _, err := intervalStart(time.Now(), interval); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range fields {
+		switch v.Func {
+		case "", "count", "sum", "avg", "max", "min":
+		default:
+			return nil, errors.New("Aggregate function '" + v.Func + "' is not supported")
+		}
+		if v.Func == "" && v.Field == "*" {
+			return nil, errors.New("'group by' query field can not be '*'")
+		}
+	}
+	// 按分组key保存数据，keys保持第一次出现的顺序
+	groups := make(map[string][]map[string]interface{})
+	keys := make([]string, 0)
+	buckets := make(map[string]time.Time)
+	for _, row := range rows {
+		key := ""
+		if interval != "" {
+			val, _ := memoryValue(row, "time")
+			t, ok := memoryTime(val)
+			if !ok {
+				continue
+			}
+			// 年、季度、月按日历分组
+			bucket, _ := intervalStart(t, interval)
+			bucket = bucket.Local()
+			key = strconv.FormatInt(bucket.Unix(), 10)
+			buckets[key] = bucket
+		}
+		for _, v := range groupFields {
+			val, _ := memoryValue(row, v)
+			key += fmt.Sprintf("\x00%T:%v", val, val)
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], row)
+	}
+	// 没有分组字段时整体聚合
+	if zql.GroupBy == "" && len(keys) == 0 {
+		keys = append(keys, "")
+	}
+	result := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		group := groups[key]
+		rowMap := make(map[string]interface{})
+		if interval != "" {
+			rowMap["time"] = buckets[key]
+		}
+		for _, v := range groupFields {
+			if len(group) > 0 {
+				rowMap[v], _ = memoryValue(group[0], v)
+			}
+		}
+		for _, v := range fields {
+			rowMap[v.Name()] = memoryAggregate(v, group)
+		}
+		result = append(result, rowMap)
+	}
+	return result, nil
+}
+
+// 计算单个聚合函数
+func memoryAggregate(field *SelectField, rows []map[string]interface{}) interface{} {
+	switch field.Func {
+	case "count":
+		count := 0
+		for _, row := range rows {
+			if val, ok := memoryValue(row, field.Field); field.Field == "*" || (ok && val != nil) {
+				count++
+			}
+		}
+		return count
+	case "sum", "avg":
+		sum := 0.0
+		count := 0
+		for _, row := range rows {
+			val, _ := memoryValue(row, field.Field)
+			if num, ok := memoryNumber(val); ok {
+				sum += num
+				count++
+			}
+		}
+		if field.Func == "sum" {
+			return sum
+		}
+		if count == 0 {
+			return nil
+		}
+		return sum / float64(count)
+	case "max", "min":
+		var out interface{}
+		for _, row := range rows {
+			val, ok := memoryValue(row, field.Field)
+			if !ok || val == nil {
+				continue
+			}
+			if out == nil {
+				out = val
+				continue
+			}
+			cmp, ok := memoryCompare(val, out)
+			if ok && ((field.Func == "max" && cmp > 0) || (field.Func == "min" && cmp < 0)) {
+				out = val
+			}
+		}
+		return out
+	}
+	// 普通字段取第一条，与mongodb的$first一致
+	if len(rows) == 0 {
+		return nil
+	}
+	val, _ := memoryValue(rows[0], field.Field)
+	return val
+}
+
+// 排序，alias为别名对应的原字段
+func memorySort(rows []map[string]interface{}, orders []*OrderField, alias map[string]string) {
+	if len(orders) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, v := range orders {
+			field := v.Field
+			if name, ok := alias[field]; ok {
+				field = name
+			}
+			a, _ := memoryValue(rows[i], field)
+			b, _ := memoryValue(rows[j], field)
+			cmp, ok := memoryCompare(a, b)
+			if !ok || cmp == 0 {
+				continue
+			}
+			if v.Desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
+
+// 判断数据是否满足条件
+func memoryMatch(node *WhereNode, row map[string]interface{}) (bool, error) {
+	if node == nil {
+		return true, nil
+	}
+	if !node.IsLeaf() {
+		for _, v := range node.Children {
+			ok, err := memoryMatch(v, row)
+			if err != nil {
+				return false, err
+			}
+			if node.Op == "or" && ok {
+				return true, nil
+			}
+			if node.Op == "and" && !ok {
+				return false, nil
+			}
+		}
+		return node.Op == "and", nil
+	}
+	val, _ := memoryValue(row, node.Field)
+	switch node.Exp {
+	case "=", "!=", "<", "<=", ">", ">=":
+		expVal, err := memoryLiteral(node.Value)
+		if err != nil {
+			return false, err
+		}
+		// 范围比较时没有字段或null不匹配，与mongodb和elasticsearch一致
+		if (val == nil || expVal == nil) && node.Exp != "=" && node.Exp != "!=" {
+			return false, nil
+		}
+		// 类型不同无法比较时!=匹配，其它不匹配，与mongodb的$ne和elasticsearch的must_not一致
+		cmp, ok := memoryCompare(val, expVal)
+		if !ok {
+			return node.Exp == "!=", nil
+		}
+		switch node.Exp {
+		case "=":
+			return cmp == 0, nil
+		case "!=":
+			return cmp != 0, nil
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		}
+		return cmp >= 0, nil
+	case "in":
+		if node.Value[:1] != "(" || node.Value[len(node.Value)-1:] != ")" {
+			return false, errors.New("Single condition error:" + node.Value)
+		}
+		for _, v := range splitTopLevel(node.Value[1:len(node.Value)-1], ',') {
+			expVal, err := memoryLiteral(v)
+			if err != nil {
+				return false, err
+			}
+			if cmp, ok := memoryCompare(val, expVal); ok && cmp == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "like", "ilike":
+		str, ok := val.(string)
+		if !ok {
+			return false, nil
+		}
+		return likeRegexp(strings.Trim(node.Value, "'"), node.Exp == "ilike").MatchString(str), nil
+	case "regexp", "=~":
+		str, ok := val.(string)
+		if !ok {
+			return false, nil
+		}
+		reg, err := regexp.Compile(strings.Trim(node.Value, "'"))
+		if err != nil {
+			return false, errors.New("Single condition regexp error:" + err.Error())
+		}
+		return reg.MatchString(str), nil
+	}
+	return false, errors.New("Operator '" + node.Exp + "' is not supported")
+}
+
+// 解析条件中的常量
+func memoryLiteral(str string) (interface{}, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, errors.New("Single condition value is empty")
+	}
+	if len(str) >= 2 && str[:1] == "'" && str[len(str)-1:] == "'" {
+		return str[1 : len(str)-1], nil
+	}
+	switch str {
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	// 相对当前时间 now()-1h
+	isDateArr := strings.Split(str, "-")
+	if strings.TrimSpace(isDateArr[0]) == "now()" {
+		if len(isDateArr) == 1 {
+			return time.Now(), nil
+		}
+		cha, err := ChaDateTime(isDateArr[1])
+		if err != nil || len(isDateArr) != 2 {
+			return nil, errors.New("Time expression error:" + str)
+		}
+		return time.Unix(time.Now().Unix()-cha, 0), nil
+	}
+	// 指定时间 date('2017-01-01 00:00:00')
+	if strings.Index(str, "date(") == 0 && str[len(str)-1:] == ")" {
+		dateStr := strings.Trim(strings.TrimSpace(str[5:len(str)-1]), "'")
+		dateTime, err := time.ParseInLocation("2006-01-02 15:04:05", dateStr, time.Local)
+		if err != nil {
+			return nil, errors.New("Query keywords 'where' error:" + err.Error())
+		}
+		return dateTime, nil
+	}
+	num, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return nil, errors.New("Single condition value error:" + str)
+	}
+	return num, nil
+}
+
+// 按a.b.c取值
+func memoryValue(row map[string]interface{}, field string) (interface{}, bool) {
+	if val, ok := row[field]; ok {
+		return val, true
+	}
+	var cur interface{} = row
+	for _, v := range strings.Split(field, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[v]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// 按memoryValue的方式删除字段，删除后为空的上级对象一并删除
+func memoryDelete(row map[string]interface{}, field string) {
+	if _, ok := row[field]; ok {
+		delete(row, field)
+		return
+	}
+	keys := strings.SplitN(field, ".", 2)
+	if len(keys) != 2 {
+		return
+	}
+	if m, ok := row[keys[0]].(map[string]interface{}); ok {
+		memoryDelete(m, keys[1])
+		if len(m) == 0 {
+			delete(row, keys[0])
+		}
+	}
+}
+
+// 比较两个值，类型不能比较时返回false
+func memoryCompare(a, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0, true
+		}
+		if a == nil {
+			return -1, true
+		}
+		return 1, true
+	}
+	// 时间比较，数字按秒级时间戳处理
+	if bt, ok := b.(time.Time); ok {
+		at, ok := memoryTime(a)
+		if !ok {
+			return 0, false
+		}
+		return compareInt64(at.UnixNano(), bt.UnixNano()), true
+	}
+	if at, ok := a.(time.Time); ok {
+		bt, ok := memoryTime(b)
+		if !ok {
+			return 0, false
+		}
+		return compareInt64(at.UnixNano(), bt.UnixNano()), true
+	}
+	// 只有两边都是数字类型时按数字比较，字符串按字典序比较，与mongodb和elasticsearch一致
+	_, aStr := a.(string)
+	_, bStr := b.(string)
+	if an, ok := memoryNumber(a); ok && !aStr {
+		bn, ok := memoryNumber(b)
+		if !ok || bStr {
+			return 0, false
+		}
+		if an < bn {
+			return -1, true
+		} else if an > bn {
+			return 1, true
+		}
+		return 0, true
+	}
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		if ab == bb {
+			return 0, true
+		} else if bb {
+			return -1, true
+		}
+		return 1, true
+	}
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if !aok || !bok {
+		return 0, false
+	}
+	return strings.Compare(as, bs), true
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// 转为数字，数字字符串同样可以比较
+func memoryNumber(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		num, err := strconv.ParseFloat(v, 64)
+		return num, err == nil
+	}
+	return 0, false
+}
+
+// 转为时间，支持time.Time、秒级时间戳和时间字符串
+func memoryTime(val interface{}) (time.Time, bool) {
+	switch v := val.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, true
+			}
+		}
+		return time.Time{}, false
+	}
+	if num, ok := memoryNumber(val); ok {
+		return time.Unix(int64(num), 0), true
+	}
+	return time.Time{}, false
+}
+
+// sql like 转为正则，% 匹配任意字符，_ 匹配单个字符
+func likeRegexp(str string, ignoreCase bool) *regexp.Regexp {
+	flags := "s"
+	if ignoreCase {
+		flags += "i"
+	}
+	return regexp.MustCompile("(?" + flags + ")" + likePattern(str))
+}
+
+// sql like 转为首尾锚定的正则表达式，其它正则字符转义
+func likePattern(str string) string {
+	reg := ""
+	for _, v := range str {
+		switch v {
+		case '%':
+			reg += ".*"
+		case '_':
+			reg += "."
+		default:
+			reg += regexp.QuoteMeta(string(v))
+		}
+	}
+	return "^" + reg + "$"
+}
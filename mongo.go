@@ -2,15 +2,17 @@ package zql
 
 // 不再强制转换字段名 2017-01-05
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
+	mgobson "gopkg.in/mgo.v2/bson"
 )
 
 // 执行
@@ -22,187 +24,478 @@ func (zql *Zql) GetMongoQuery(mgoDb *mgo.Database, subTname string, list *[]map[
 	if mgoQuery != nil {
 		return mgoQuery.All(list)
 	} else if mgoPipe != nil {
-		return mgoPipe.All(list)
+		if err = mgoPipe.All(list); err != nil {
+			return err
+		}
+		// 服务端不支持$densify/$fill时查询后填充
+		*list, err = zql.MongoFillRows(*list)
+		return err
 	}
 	return nil
 }
 
-// 只返回查询字符串
+// 只返回查询字符串，不需要数据库连接，mgoDb可以为nil
 func (zql *Zql) GetMongoQueryStr(mgoDb *mgo.Database, subTname string) (string, error) {
-	_, _, str, err := zql.GetMongoQueryDetails(mgoDb, subTname)
-	return str, err
+	return zql.MongoShell(subTname)
 }
 
-// 执行并返回查询字符串
+// 执行并返回查询字符串，查询字符串为mongo shell写法
 func (zql *Zql) GetMongoQueryDetails(mgoDb *mgo.Database, subTname string) (*mgo.Query, *mgo.Pipe, string, error) {
 	if zql.Select == "" || zql.From == "" {
 		return nil, nil, "", errors.New("Query string does not exist 'select|from'")
 	}
-	// fmt.Println(zql.MongodbTableName(zql.Prefix+zql.From, subTname))
 	// 构建mongodb查询对象 from
-	collection := mgoDb.C(zql.MongodbTableName(zql.Prefix+zql.From, subTname)) // 数据表
-	// 根据是否分组查询(group by)区分查询方式
-	if zql.GroupBy == "" {
-		var mQuery *mgo.Query
-		// 判断是否有where条件
-		if zql.Where == "" {
-			mQuery = collection.Find(bson.M{})
-		} else {
-			where, err := handleWhereToMap(zql.Where)
-			if err != nil {
-				return nil, nil, "", err
-			} else {
-				mQuery = collection.Find(where)
-			}
+	collection := mgoDb.C(zql.mongoCollection(subTname)) // 数据表
+	// 根据是否分组查询(group by)或有别名区分查询方式
+	if !zql.MongoUseAggregate() {
+		find, err := zql.MongoFind()
+		if err != nil {
+			return nil, nil, "", err
 		}
+		mQuery := collection.Find(toMgoValue(find.Filter))
 		// 查询字段列表
-		if zql.Select != "*" {
-			selList := strings.Split(zql.Select, ",")
-			selectM := make(bson.M)
-			for _, v := range selList {
-				selectM[v] = 1
+		if find.Projection != nil {
+			mQuery.Select(toMgoValue(find.Projection))
+		}
+		// 排序
+		if len(find.Sort) > 0 {
+			mQuery.Sort(find.sortFields()...)
+		}
+		// 分页
+		if find.Skip > 0 {
+			mQuery.Skip(find.Skip) // 跳过
+		}
+		if find.Limit >= 0 {
+			mQuery.Limit(find.Limit) // 查询条数
+		}
+		return mQuery, nil, find.shell(collection.Name), nil
+	}
+	// group by、别名和关联查询情况
+	groupBson, err := zql.mongoPipeline(subTname)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	allowDiskUse := zql.mongoOptions().AllowDiskUse
+	mgoPipe := collection.Pipe(toMgoValue(groupBson))
+	if allowDiskUse {
+		mgoPipe.AllowDiskUse()
+	}
+	return nil, mgoPipe, mongoShellAggregate(collection.Name, groupBson, allowDiskUse), nil
+}
+
+// 查询对象转为mgo的bson类型，只用于mgo执行
+func toMgoValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case bson.M:
+		doc := make(mgobson.M, len(v))
+		for key, e := range v {
+			doc[key] = toMgoValue(e)
+		}
+		return doc
+	case bson.D:
+		doc := make(mgobson.D, 0, len(v))
+		for _, e := range v {
+			doc = append(doc, mgobson.DocElem{Name: e.Key, Value: toMgoValue(e.Value)})
+		}
+		return doc
+	case []bson.M:
+		arr := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			arr = append(arr, toMgoValue(e))
+		}
+		return arr
+	case bson.A:
+		return toMgoValue([]interface{}(v))
+	case []interface{}:
+		arr := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			arr = append(arr, toMgoValue(e))
+		}
+		return arr
+	case primitive.ObjectID:
+		return mgobson.ObjectId(v[:])
+	case primitive.Regex:
+		return mgobson.RegEx{Pattern: v.Pattern, Options: v.Options}
+	}
+	return val
+}
+
+// MongoFindQuery 普通查询的各部分，不依赖数据库连接，可用于mgo或官方驱动
+type MongoFindQuery struct {
+	Collection string // 表名，不包含subTname
+	Filter     bson.M // 查询条件
+	Projection bson.M // 查询字段，nil时返回全部字段
+	Sort       bson.D // 排序，1正序 -1倒序
+	Skip       int
+	Limit      int // 小于0时不限制
+}
+
+// MongoFind 生成普通查询的条件、字段、排序和分页，不需要数据库连接
+func (zql *Zql) MongoFind() (*MongoFindQuery, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, errors.New("Query string does not exist 'select|from'")
+	}
+	if zql.isMongoJoin() {
+		return nil, errors.New("'join' query must use MongoPipeline")
+	}
+	find := &MongoFindQuery{Collection: zql.Prefix + zql.From, Filter: bson.M{}}
+	// 判断是否有where条件
+	if zql.Where != "" {
+		where, err := handleWhereToMap(zql.Where, zql.mongoOptions())
+		if err != nil {
+			return nil, err
+		}
+		find.Filter = where
+	}
+	// 查询字段列表
+	projection, _, err := zql.mongoProjection()
+	if err != nil {
+		return nil, err
+	}
+	find.Projection = projection
+	// 判断是否有排序
+	if zql.OrderBy != "" {
+		for _, v := range zql.ParseOrderBy() {
+			if v.Desc {
+				find.Sort = append(find.Sort, bson.E{Key: v.Field, Value: -1})
+			} else {
+				find.Sort = append(find.Sort, bson.E{Key: v.Field, Value: 1})
 			}
-			mQuery.Select(selectM)
-		}
-		// 判断是否有排序
-		if zql.OrderBy != "" {
-			// 这里可能有多个排序","分开
-			sortList := strings.Split(zql.OrderBy, ",")
-			for _, v := range sortList {
-				// 按空格分开
-				sortExp := strings.Fields(v)
-				if len(sortExp) != 2 {
-					mQuery.Sort(sortExp[0])
-				} else {
-					if sortExp[1] == "asc" {
-						mQuery.Sort(sortExp[0])
-					} else if sortExp[1] == "desc" {
-						mQuery.Sort("-" + sortExp[0])
-					}
-				}
+		}
+	} else {
+		// 不存在排序，使用表配置的默认排序
+		find.Sort = zql.mongoOptions().defaultSort()
+	}
+	// 分页
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return nil, errors.New("Error in 'limit' expression")
+	}
+	find.Skip, find.Limit = skip, limit
+	return find, nil
+}
+
+// MongoUseAggregate 是否需要使用aggregate执行，分组查询或有别名、计算字段时为true
+func (zql *Zql) MongoUseAggregate() bool {
+	if zql.GroupBy != "" || zql.isMongoJoin() {
+		return true
+	}
+	_, aliases, err := zql.mongoProjection()
+	return err == nil && len(aliases) > 0
+}
+
+// 普通字段名，可以是a.b.c
+var mongoFieldReg = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`)
+
+// 查询字段列表，返回别名对应的字段或计算表达式
+// select id as aid, a.b.c, price * qty as total 或 select * except password
+func (zql *Zql) mongoProjection() (bson.M, map[string]interface{}, error) {
+	sel := strings.TrimSpace(zql.Select)
+	aliases := make(map[string]interface{})
+	if sel == "*" {
+		return nil, aliases, nil
+	}
+	projection := make(bson.M)
+	// 排除字段
+	if strings.Index(sel, "* except ") == 0 {
+		for _, v := range strings.Split(sel[len("* except "):], ",") {
+			v = strings.TrimSpace(v)
+			if !mongoFieldReg.MatchString(v) {
+				return nil, nil, errors.New("Field 'select' format error:" + v)
 			}
-		} else {
-			// 不存在排序，则使用时间排序
-			mQuery.Sort("datetime")
+			projection[v] = 0
 		}
-		// 分页
-		if zql.Limit != "" {
-			// 切割范围
-			limitList := strings.Split(zql.Limit, ",")
-			if len(limitList) == 1 {
-				limitInt, err := strconv.Atoi(strings.TrimSpace(limitList[0]))
-				if err != nil {
-					return nil, nil, "", errors.New("Error in 'limit' expression")
-				}
-				mQuery.Limit(limitInt)
-			} else if len(limitList) == 2 {
-				skipInt, err := strconv.Atoi(strings.TrimSpace(limitList[0]))
-				if err != nil {
-					return nil, nil, "", errors.New("Error in 'skip' expression")
-				}
-				mQuery.Skip(skipInt) // 跳过
-				limitInt, err := strconv.Atoi(strings.TrimSpace(limitList[1]))
-				if err != nil {
-					return nil, nil, "", errors.New("Error in 'limit' expression")
-				}
-				mQuery.Limit(limitInt) // 查询条数
+		return projection, aliases, nil
+	}
+	for _, v := range splitTopLevel(sel, ',') {
+		fields := strings.Split(strings.TrimSpace(v), " as ")
+		expr := strings.TrimSpace(fields[0])
+		if len(fields) > 2 || expr == "" {
+			return nil, nil, errors.New("Field 'select' format error:" + v)
+		}
+		alias := ""
+		if len(fields) == 2 {
+			alias = strings.TrimSpace(fields[1])
+			if !mongoFieldReg.MatchString(alias) || strings.Contains(alias, ".") {
+				return nil, nil, errors.New("Field 'select' alias error:" + v)
 			}
 		}
-		//		fmt.Println(mQuery)
-		//		err := mQuery.All(list)
-		//		fmt.Println(list) // ### 删除
-		js, _ := json.Marshal(mQuery)
-		// log.Println(string(js))
-		//		err := mQuery.All(list)
-		return mQuery, nil, string(js), nil
-	} else { // group by 情况
-		groupBson := make([]bson.M, 0)
-		// 判断是否有where条件
-		if zql.Where != "" {
-			where, err := handleWhereToMap(zql.Where)
-			if err != nil {
-				return nil, nil, "", err
+		if mongoFieldReg.MatchString(expr) {
+			if alias == "" || alias == expr {
+				projection[expr] = 1
 			} else {
-				groupBson = append(groupBson, bson.M{"$match": where})
+				projection[alias] = "$" + expr
+				aliases[alias] = "$" + expr
 			}
+			continue
+		}
+		// 计算字段必须有别名
+		if alias == "" {
+			return nil, nil, errors.New("Computed field '" + expr + "' needs an alias")
 		}
-		// 处理group by 部分
-		groupByBson, err := zql.MongoGroupBy()
+		val, err := mongoExpression(expr)
 		if err != nil {
-			return nil, nil, "", err
+			return nil, nil, err
 		}
-		groupBson = append(groupBson, groupByBson)
-		// order by
-		if zql.OrderBy != "" {
-			oList := strings.Split(zql.OrderBy, ",")
-			for _, v := range oList {
-				vList := strings.Fields(strings.TrimSpace(v))
-				if len(vList) == 2 {
-					if vList[1] == "desc" {
-						groupBson = append(groupBson, bson.M{"$sort": bson.M{vList[0]: -1}})
-					} else {
-						groupBson = append(groupBson, bson.M{"$sort": bson.M{vList[0]: 1}})
-					}
+		projection[alias] = val
+		aliases[alias] = val
+	}
+	return projection, aliases, nil
+}
+
+// 没有分组时的pipeline，用于别名和计算字段
+func (zql *Zql) mongoProjectPipeline() ([]bson.M, error) {
+	find, err := zql.MongoFind()
+	if err != nil {
+		return nil, err
+	}
+	_, aliases, err := zql.mongoProjection()
+	if err != nil {
+		return nil, err
+	}
+	pipeline := make([]bson.M, 0)
+	if len(find.Filter) > 0 {
+		pipeline = append(pipeline, bson.M{"$match": find.Filter})
+	}
+	// 排序字段是别名时，重命名使用原字段，计算字段先添加到文档中
+	if len(find.Sort) > 0 {
+		addFields := bson.M{}
+		sortBson := bson.D{}
+		for _, v := range find.Sort {
+			// $sort 阶段不支持$natural，没有排序时即为存储顺序
+			if v.Key == "$natural" {
+				continue
+			}
+			if val, ok := aliases[v.Key]; ok {
+				if field, ok := val.(string); ok {
+					v.Key = field[1:]
 				} else {
-					groupBson = append(groupBson, bson.M{"$sort": bson.M{vList[0]: 1}})
+					addFields[v.Key] = val
 				}
 			}
+			sortBson = append(sortBson, v)
 		}
-		// limit
-		if zql.Limit != "" {
-			lList := strings.Split(zql.Limit, ",")
-			limit1, err := strconv.Atoi(strings.TrimSpace(lList[0]))
-			if err != nil {
-				return nil, nil, "", errors.New("Query keywords 'limit' error")
+		if len(addFields) > 0 {
+			pipeline = append(pipeline, bson.M{"$addFields": addFields})
+		}
+		if len(sortBson) > 0 {
+			pipeline = append(pipeline, bson.M{"$sort": sortBson})
+		}
+	}
+	if find.Skip > 0 {
+		pipeline = append(pipeline, bson.M{"$skip": find.Skip}) // 跳过文档数
+	}
+	if find.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": find.Limit}) // 查询文档数
+	}
+	if find.Projection != nil {
+		pipeline = append(pipeline, bson.M{"$project": find.Projection})
+	}
+	return pipeline, nil
+}
+
+// 计算字段表达式转为聚合表达式，支持 + - * / 和小括号
+func mongoExpression(str string) (interface{}, error) {
+	tokens := make([]string, 0)
+	token := ""
+	for _, v := range str {
+		if strings.ContainsRune("+-*/() ", v) {
+			if token != "" {
+				tokens = append(tokens, token)
+				token = ""
 			}
-			if len(lList) == 2 {
-				limit2, err := strconv.Atoi(strings.TrimSpace(lList[1]))
-				if err != nil {
-					return nil, nil, "", errors.New("Query keywords 'limit' error")
-				}
-				groupBson = append(groupBson, bson.M{"$skip": limit1})  // 跳过文档数
-				groupBson = append(groupBson, bson.M{"$limit": limit2}) // 查询文档数
-			} else {
-				groupBson = append(groupBson, bson.M{"$limit": limit1}) // 查询文档数
+			if v != ' ' {
+				tokens = append(tokens, string(v))
 			}
+			continue
 		}
-		js, _ := json.Marshal(groupBson)
-		//		err = collection.Pipe(groupBson).All(list)
-		return nil, collection.Pipe(groupBson), string(js), err
+		token += string(v)
+	}
+	if token != "" {
+		tokens = append(tokens, token)
 	}
+	parser := &mongoExprParser{tokens: tokens}
+	val, err := parser.expr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos != len(tokens) {
+		return nil, errors.New("Computed field format error:" + str)
+	}
+	return val, nil
+}
 
-	return nil, nil, "", nil
+// 表达式解析
+type mongoExprParser struct {
+	tokens []string
+	pos    int
+}
+
+var mongoExprOperators = map[string]string{"+": "$add", "-": "$subtract", "*": "$multiply", "/": "$divide"}
+
+// expr := term (('+'|'-') term)*
+func (parser *mongoExprParser) expr() (interface{}, error) {
+	return parser.binary("+-", parser.term)
+}
+
+// term := factor (('*'|'/') factor)*
+func (parser *mongoExprParser) term() (interface{}, error) {
+	return parser.binary("*/", parser.factor)
+}
+
+func (parser *mongoExprParser) binary(ops string, next func() (interface{}, error)) (interface{}, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for parser.pos < len(parser.tokens) && strings.Contains(ops, parser.tokens[parser.pos]) {
+		op := parser.tokens[parser.pos]
+		parser.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = bson.M{mongoExprOperators[op]: []interface{}{left, right}}
+	}
+	return left, nil
+}
+
+// factor := number | field | '(' expr ')'
+func (parser *mongoExprParser) factor() (interface{}, error) {
+	if parser.pos >= len(parser.tokens) {
+		return nil, errors.New("Computed field format error: unexpected end")
+	}
+	token := parser.tokens[parser.pos]
+	parser.pos++
+	if token == "(" {
+		val, err := parser.expr()
+		if err != nil {
+			return nil, err
+		}
+		if parser.pos >= len(parser.tokens) || parser.tokens[parser.pos] != ")" {
+			return nil, errors.New("Computed field format error: missing ')'")
+		}
+		parser.pos++
+		return val, nil
+	}
+	if num, err := strconv.ParseFloat(token, 64); err == nil {
+		if i, err := strconv.Atoi(token); err == nil {
+			return i, nil
+		}
+		return num, nil
+	}
+	if !mongoFieldReg.MatchString(token) {
+		return nil, errors.New("Computed field format error:" + token)
+	}
+	return "$" + token, nil
+}
+
+// MongoPipeline 生成分组查询的pipeline，不需要数据库连接，关联表名不添加subTname
+func (zql *Zql) MongoPipeline() ([]bson.M, error) {
+	return zql.mongoPipeline("")
+}
+
+// 生成pipeline，subTname用于关联表名
+func (zql *Zql) mongoPipeline(subTname string) ([]bson.M, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, errors.New("Query string does not exist 'select|from'")
+	}
+	// 关联查询
+	base, joins, err := zql.mongoJoinBase()
+	if err != nil {
+		return nil, err
+	}
+	if len(joins) > 0 {
+		return base.mongoJoinPipeline(joins, subTname)
+	}
+	// 没有分组时只处理别名和计算字段
+	if zql.GroupBy == "" {
+		return zql.mongoProjectPipeline()
+	}
+	groupBson := make([]bson.M, 0)
+	// 判断是否有where条件
+	if zql.Where != "" {
+		where, err := handleWhereToMap(zql.Where, zql.mongoOptions())
+		if err != nil {
+			return nil, err
+		}
+		groupBson = append(groupBson, bson.M{"$match": where})
+	}
+	// 处理group by 部分
+	groupByBson, err := zql.mongoGroupBy()
+	if err != nil {
+		return nil, err
+	}
+	groupBson = append(groupBson, groupByBson)
+	// 按时间分组时填充空分组，并增加time列
+	_, interval := zql.ParseGroupBy()
+	if interval != "" {
+		fillBson, err := zql.mongoFillPipeline(interval)
+		if err != nil {
+			return nil, err
+		}
+		groupBson = append(groupBson, fillBson...)
+		groupBson = append(groupBson, bson.M{"$addFields": bson.M{"time": "$_id"}})
+	}
+	// order by 多个排序字段放在同一个$sort中
+	if zql.OrderBy != "" {
+		sortBson := bson.D{}
+		for _, v := range zql.ParseOrderBy() {
+			if v.Desc {
+				sortBson = append(sortBson, bson.E{Key: v.Field, Value: -1})
+			} else {
+				sortBson = append(sortBson, bson.E{Key: v.Field, Value: 1})
+			}
+		}
+		groupBson = append(groupBson, bson.M{"$sort": sortBson})
+	} else if interval != "" {
+		// 按时间分组默认按时间正序
+		groupBson = append(groupBson, bson.M{"$sort": bson.D{{Key: "_id", Value: 1}}})
+	}
+	// 查询后填充时分页在填充后处理
+	if zql.mongoPostFill() {
+		return groupBson, nil
+	}
+	// limit
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return nil, errors.New("Query keywords 'limit' error")
+	}
+	if skip > 0 {
+		groupBson = append(groupBson, bson.M{"$skip": skip}) // 跳过文档数
+	}
+	if limit >= 0 {
+		groupBson = append(groupBson, bson.M{"$limit": limit}) // 查询文档数
+	}
+	return groupBson, nil
 }
 
 // 处理group by
-func (zql *Zql) MongoGroupBy() (bson.M, error) {
+func (zql *Zql) MongoGroupBy() (mgobson.M, error) {
+	group, err := zql.mongoGroupBy()
+	if group == nil {
+		return nil, err
+	}
+	return toMgoValue(group).(mgobson.M), err
+}
+
+// 生成$group阶段
+func (zql *Zql) mongoGroupBy() (bson.M, error) {
 	group := make(bson.M, 0)
 	// 判断分组是否是按时间分组
-	if strings.Index(zql.GroupBy, "time(") == 0 {
+	if _, interval := zql.ParseGroupBy(); interval != "" {
 		// 获取时间
-		stepTime, err := ChaDateTime(string(zql.GroupBy[5 : len(zql.GroupBy)-1]))
+		stepTime, err := ChaDateTime(interval)
 		if err != nil {
 			return bson.M{}, errors.New("Query keywords 'group by' error")
 		}
-		group = bson.M{
-			"_id": bson.M{
-				"$subtract": []bson.M{
-					bson.M{
-						"$divide": []interface{}{"$datetime", stepTime},
-					},
-					bson.M{
-						"$mod": []interface{}{
-							bson.M{
-								"$divide": []interface{}{"$datetime", stepTime},
-							},
-							1,
-						},
-					},
-				},
-			},
+		id, err := zql.mongoTimeBucket(interval, stepTime)
+		if err != nil {
+			return bson.M{}, err
 		}
+		group = bson.M{"_id": id}
 	} else {
-		group = bson.M{"_id": "$" + zql.GroupBy}
+		group = bson.M{"_id": "$" + fillReg.ReplaceAllString(zql.GroupBy, "")}
 	}
 	if zql.Select == "*" {
 		return group, errors.New("'group by' query field can not be '*'")
@@ -234,6 +527,70 @@ func (zql *Zql) MongoGroupBy() (bson.M, error) {
 	return bson.M{"$group": group}, nil
 }
 
+// 按时间分组的_id，为分组开始时间(Date)
+func (zql *Zql) mongoTimeBucket(interval string, stepTime int64) (interface{}, error) {
+	opts := zql.mongoOptions()
+	timeField := "$" + opts.TimeField
+	num, unit, err := splitInterval(interval)
+	if err != nil {
+		return nil, errors.New("Query keywords 'group by' error")
+	}
+	// 年、季度、月不是固定长度，使用$dateTrunc按日历截取，时间戳先转为Date
+	if isCalendarUnit(unit) {
+		if opts.ServerVersion != "" && !opts.versionAtLeast(5, 0) {
+			return nil, errors.New("Group by years, quarters or months requires mongodb 5.0 or later")
+		}
+		var date interface{} = timeField
+		switch opts.TimeType {
+		case MongoTimeDate:
+		case MongoTimeUnixMs:
+			date = bson.M{"$toDate": timeField}
+		default:
+			date = bson.M{"$toDate": bson.M{"$multiply": []interface{}{timeField, 1000}}}
+		}
+		return bson.M{"$dateTrunc": bson.M{"date": date, "unit": mongoDensifyUnits[unit], "binSize": num}}, nil
+	}
+	// 按周分组时先减去到周一的偏移再取余数
+	offset := intervalOffset(unit)
+	switch opts.TimeType {
+	case MongoTimeDate:
+		// Date减去毫秒数仍然是Date
+		return bson.M{
+			"$subtract": []interface{}{
+				timeField,
+				bson.M{"$mod": []interface{}{bson.M{"$subtract": []interface{}{timeField, time.Unix(offset, 0)}}, stepTime * 1000}},
+			},
+		}, nil
+	case MongoTimeUnixMs:
+		// 毫秒时间戳去掉余数后加到1970-01-01得到Date
+		stepTime = stepTime * 1000
+		return bson.M{
+			"$add": []interface{}{
+				time.Unix(0, 0),
+				bson.M{"$subtract": []interface{}{timeField, bson.M{"$mod": []interface{}{mongoOffsetField(timeField, offset*1000), stepTime}}}},
+			},
+		}, nil
+	}
+	// 秒级时间戳去掉余数后转为毫秒，加到1970-01-01得到Date
+	return bson.M{
+		"$add": []interface{}{
+			time.Unix(0, 0),
+			bson.M{"$multiply": []interface{}{
+				bson.M{"$subtract": []interface{}{timeField, bson.M{"$mod": []interface{}{mongoOffsetField(timeField, offset), stepTime}}}},
+				1000,
+			}},
+		},
+	}, nil
+}
+
+// 时间戳减去偏移量，没有偏移时直接使用字段
+func mongoOffsetField(timeField string, offset int64) interface{} {
+	if offset == 0 {
+		return timeField
+	}
+	return bson.M{"$subtract": []interface{}{timeField, offset}}
+}
+
 // 获取select fields转成对应聚合函数
 func fieldsAggregationName(str string) (string, string) {
 	str = strings.TrimSpace(str)
@@ -246,8 +603,8 @@ func fieldsAggregationName(str string) (string, string) {
 	return "$first", "$" + str
 }
 
-// 将where字符串转成mongodb bson条件
-func handleWhereToMap(str string) (bson.M, error) {
+// 将where字符串转成mongodb bson条件，opts决定时间字段和时间值的类型
+func handleWhereToMap(str string, opts *MongoOptions) (bson.M, error) {
 	// 拆分数据
 	whereList := whrere(str)
 	// 当前操作符
@@ -256,7 +613,7 @@ func handleWhereToMap(str string) (bson.M, error) {
 	orWhereArray := make([]bson.M, 0)
 	for k, v := range whereList {
 		if v == "or" { // 分隔每组and条件
-			andWhereArray, err := handleAndWhere(whereList[key:k])
+			andWhereArray, err := handleAndWhere(whereList[key:k], opts)
 			if err != nil {
 				return nil, err
 			}
@@ -265,7 +622,7 @@ func handleWhereToMap(str string) (bson.M, error) {
 		}
 		//		fmt.Println(k, v)
 	}
-	andWhereArray, err := handleAndWhere(whereList[key:])
+	andWhereArray, err := handleAndWhere(whereList[key:], opts)
 	if err != nil {
 		return nil, err
 	}
@@ -275,7 +632,7 @@ func handleWhereToMap(str string) (bson.M, error) {
 }
 
 // and 条件数据
-func handleAndWhere(andArr []string) ([]bson.M, error) {
+func handleAndWhere(andArr []string, opts *MongoOptions) ([]bson.M, error) {
 	// 存储一个and数组
 	andWhereArray := make([]bson.M, 0)
 	// 循环组织条件
@@ -286,57 +643,64 @@ func handleAndWhere(andArr []string) ([]bson.M, error) {
 			if err != nil {
 				return nil, err
 			}
-			// 判断值是字符还是数字, #@#@#@#@ 测试时可以去掉测试
+			// 判断值的类型：时间、in列表或常量
 			var expVal interface{}
+			isDateArr := strings.Split(expression[2], "-")
 			if string(expression[2][0:1]) == "'" {
-				expVal = strings.Trim(expression[2], "'")
+				expVal, err = mongoValue(expression[0], expression[2], opts)
+				if err != nil {
+					return nil, err
+				}
+			} else if strings.TrimSpace(isDateArr[0]) == "now()" && len(isDateArr) == 2 {
+				// 判断是否是相对于当前时间的查询
+				// 如果用户输入的字段时time，这里强制成配置的时间字段
+				if expression[0] == "time" {
+					expression[0] = opts.TimeField
+				}
+				cha, err := ChaDateTime(isDateArr[1])
+				if err != nil {
+					expVal = expression[2]
+				} else {
+					// 按时间字段的存储类型使用ISODate或时间戳
+					expVal = opts.timeValue(time.Unix(time.Now().Local().Unix()-cha, 0))
+				}
+			} else if strings.Index(strings.TrimSpace(expression[2]), "date(") == 0 {
+				// 如果用户输入的字段时time，这里强制成配置的时间字段
+				if expression[0] == "time" {
+					expression[0] = opts.TimeField
+				}
+				dateStr := strings.Trim(strings.TrimSpace(expression[2][strings.Index(expression[2], "(")+1:strings.Index(expression[2], ")")]), "'")
+				dateStrTime, err := time.ParseInLocation("2006-01-02 15:04:05", dateStr, time.Local)
+				if err != nil {
+					return nil, errors.New("Query keywords 'where' error:" + err.Error())
+				}
+				expVal = opts.timeValue(dateStrTime)
+			} else if expression[1] == "$in" {
+				expVal, err = mongoInList(expression[0], expression[2], opts)
+				if err != nil {
+					return nil, err
+				}
 			} else {
-				expVal, err = strconv.Atoi(expression[2])
+				expVal, err = mongoValue(expression[0], expression[2], opts)
 				if err != nil {
-					// return nil, errors.New("Character conversion digital error:" + expression[2])
-					// 判断是否是相对于当前时间的查询
-					isDateArr := strings.Split(expression[2], "-")
-					if strings.TrimSpace(isDateArr[0]) == "now()" && len(isDateArr) == 2 {
-						// 如果用户输入的字段时time，这里强制成datetime
-						if expression[0] == "time" {
-							expression[0] = "datetime"
-						}
-						cha, err := ChaDateTime(isDateArr[1])
-						if err != nil {
-							expVal = expression[2]
-						} else {
-							// 时间比较字段使用时间格式没有成功，这里使用时间戳字段
-							expVal = time.Now().Local().Unix() - cha // "ISODate('" + time.Unix(time.Now().Local().Unix()-cha, 0).Format("2006-01-02 15:04:05") + "')"
-						}
-					} else if strings.Index(strings.TrimSpace(expression[2]), "date(") == 0 {
-						// 如果用户输入的字段时time，这里强制成datetime
-						if expression[0] == "time" {
-							expression[0] = "datetime"
-						}
-						dateStr := strings.Trim(strings.TrimSpace(expression[2][strings.Index(expression[2], "(")+1:strings.Index(expression[2], ")")]), "'")
-						dateStrTime, err := time.ParseInLocation("2006-01-02 15:04:05", dateStr, time.Local)
-						if err != nil {
-							return nil, errors.New("Query keywords 'where' error:" + err.Error())
-						}
-						expVal = dateStrTime.Unix()
-						// 如果用户输入的字段时time，这里强制成datetime
-						if expression[0] == "time" {
-							expression[0] = "datetime"
-						}
-					} else {
-						expVal = expression[2]
-					}
+					return nil, err
 				}
 			}
-			if expression[1] == "$regex" {
-				andWhereArray = append(andWhereArray, bson.M{expression[0]: bson.M{expression[1]: expVal, "$options": "$i"}})
-			} else if expression[1] == "$in" {
-				andWhereArray = append(andWhereArray, bson.M{expression[0]: bson.M{expression[1]: mongoInList(expVal)}})
+			if expression[1] == "$like" || expression[1] == "$ilike" {
+				// sql like 语义，转义正则字符并首尾锚定，%匹配换行与内存查询一致，ilike 忽略大小写
+				regex := bson.M{"$regex": likePattern(strings.Trim(expression[2], "'")), "$options": "s"}
+				if expression[1] == "$ilike" {
+					regex["$options"] = "is"
+				}
+				andWhereArray = append(andWhereArray, bson.M{expression[0]: regex})
+			} else if expression[1] == "$regex" {
+				// regexp 或 =~ 直接使用原始正则
+				andWhereArray = append(andWhereArray, bson.M{expression[0]: bson.M{"$regex": strings.Trim(expression[2], "'")}})
 			} else {
 				andWhereArray = append(andWhereArray, bson.M{expression[0]: bson.M{expression[1]: expVal}})
 			}
 		} else if string(vv[0:1]) == "(" {
-			andOne, err := handleWhereToMap(vv)
+			andOne, err := handleWhereToMap(vv, opts)
 			if err != nil {
 				return andWhereArray, err
 			}
@@ -427,11 +791,23 @@ func expressionStr(str string) string {
 	} else if str == "in" {
 		exp = "$in"
 	} else if str == "like" {
+		exp = "$like"
+	} else if str == "ilike" {
+		exp = "$ilike"
+	} else if str == "regexp" || str == "=~" {
 		exp = "$regex"
 	}
 	return exp
 }
 
+// 查询的表名，关联查询时为主表
+func (zql *Zql) mongoCollection(subTname string) string {
+	if base, _, err := zql.mongoJoinBase(); err == nil {
+		return zql.MongodbTableName(zql.Prefix+base.From, subTname)
+	}
+	return zql.MongodbTableName(zql.Prefix+zql.From, subTname)
+}
+
 // 格式化表名
 func (zql *Zql) MongodbTableName(tname, subTname string) string {
 	if subTname == "" {
@@ -440,26 +816,104 @@ func (zql *Zql) MongodbTableName(tname, subTname string) string {
 	return tname + "_" + subTname
 }
 
-// 转换in列表
-func mongoInList(str interface{}) []string {
-	sstr := strings.TrimSpace(fmt.Sprint(str))
-	sstr = sstr[1 : len(sstr)-1]
-	strList := strings.Split(sstr, ",")
-	strList1 := make([]string, 0)
-	for _, v := range strList {
-		strList1 = append(strList1, strings.Trim(strings.TrimSpace(v), "'"))
+// 转换in列表，每个值按类型转换
+func mongoInList(field, str string, opts *MongoOptions) ([]interface{}, error) {
+	sstr := strings.TrimSpace(str)
+	if len(sstr) < 2 || sstr[:1] != "(" || sstr[len(sstr)-1:] != ")" {
+		return nil, errors.New("Single condition error:" + str)
+	}
+	list := make([]interface{}, 0)
+	for _, v := range splitTopLevel(sstr[1:len(sstr)-1], ',') {
+		val, err := mongoValue(field, v, opts)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, val)
+	}
+	return list, nil
+}
+
+// 条件常量转为对应类型：'字符串'、整数、小数、true/false、null、objectid('...')
+// 表配置了字段类型时转为存储的类型
+func mongoValue(field, str string, opts *MongoOptions) (interface{}, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, errors.New("Single condition value is empty")
+	}
+	var val interface{} = str
+	quoted := false
+	if len(str) >= 2 && str[:1] == "'" && str[len(str)-1:] == "'" {
+		val = str[1 : len(str)-1]
+		quoted = true
+	} else if str == "null" {
+		val = nil
+	} else if str == "true" || str == "false" {
+		val = str == "true"
+	} else if strings.Index(str, "objectid(") == 0 && str[len(str)-1:] == ")" {
+		hex := strings.Trim(strings.TrimSpace(str[9:len(str)-1]), "'")
+		oid, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, errors.New("Invalid objectid:" + hex)
+		}
+		val = oid
+	} else if i, err := strconv.Atoi(str); err == nil {
+		val = i
+	} else if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+		val = i
+	} else if f, err := strconv.ParseFloat(str, 64); err == nil {
+		val = f
+	}
+	kind := opts.Schema[field]
+	if kind == "" || val == nil {
+		return val, nil
 	}
-	return strList1
+	// 按字段存储类型转换
+	text := fmt.Sprint(val)
+	if quoted {
+		text = val.(string)
+	}
+	switch kind {
+	case "string":
+		if quoted {
+			return val, nil
+		}
+		return str, nil
+	case "int":
+		if f, ok := val.(float64); ok && f == float64(int64(f)) {
+			return int64(f), nil
+		}
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, nil
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f, nil
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b, nil
+		}
+	case "objectid":
+		if oid, ok := val.(primitive.ObjectID); ok {
+			return oid, nil
+		}
+		if oid, err := primitive.ObjectIDFromHex(text); err == nil {
+			return oid, nil
+		}
+	case "date":
+		if t, ok := memoryTime(val); ok {
+			return opts.timeValue(t), nil
+		}
+	default:
+		return nil, errors.New("Unknown field type '" + kind + "' of field " + field)
+	}
+	return nil, fmt.Errorf("Value %s of field %s cannot convert to %s", str, field, kind)
 }
 
 // 字符串转时间戳秒数
 func ChaDateTime(str string) (int64, error) {
-	str = strings.TrimSpace(str)
-	// 获取各部分
-	intSub := str[0 : len(str)-1]
-	strSub := str[len(str)-1:]
-	// 转数字
-	intNum1, err := strconv.Atoi(string(intSub))
+	// 获取各部分，年、季度、月按固定天数估算
+	intNum1, strSub, err := splitInterval(str)
 	intNum := int64(intNum1)
 	if err != nil {
 		return 0, err
@@ -470,9 +924,15 @@ func ChaDateTime(str string) (int64, error) {
 	case "y":
 		outTime = 365 * 24 * 60 * 60
 		break
-	case "M":
+	case "q":
+		outTime = 90 * 24 * 60 * 60
+		break
+	case "mo":
 		outTime = 30 * 24 * 60 * 60
 		break
+	case "w":
+		outTime = 7 * 24 * 60 * 60
+		break
 	case "d":
 		outTime = 24 * 60 * 60
 		break
@@ -0,0 +1,141 @@
+package zql
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetMongoDriverQuery 使用官方驱动执行查询，返回游标
+// 服务端不支持$densify/$fill时游标中的数据未填充、未分页，需要读取全部数据后调用MongoFillRows，或使用GetMongoDriverRows
+func (zql *Zql) GetMongoDriverQuery(ctx context.Context, db *mongo.Database, subTname string) (*mongo.Cursor, error) {
+	cursor, _, err := zql.GetMongoDriverQueryDetails(ctx, db, subTname)
+	return cursor, err
+}
+
+// GetMongoDriverQueryDetails 使用官方驱动执行查询，返回游标和mongo shell写法的查询字符串
+// 游标数据同GetMongoDriverQuery，可能未填充、未分页
+func (zql *Zql) GetMongoDriverQueryDetails(ctx context.Context, db *mongo.Database, subTname string) (*mongo.Cursor, string, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, "", errors.New("Query string does not exist 'select|from'")
+	}
+	collection := db.Collection(zql.mongoCollection(subTname)) // 数据表
+	str, err := zql.MongoShell(subTname)
+	if err != nil {
+		return nil, "", err
+	}
+	// 根据是否分组查询(group by)区分查询方式
+	if !zql.MongoUseAggregate() {
+		filter, opts, err := zql.MongoDriverFind()
+		if err != nil {
+			return nil, "", err
+		}
+		cursor, err := collection.Find(ctx, filter, opts)
+		return cursor, str, err
+	}
+	pipeline, err := zql.mongoDriverPipeline(subTname)
+	if err != nil {
+		return nil, "", err
+	}
+	opts := options.Aggregate()
+	if zql.mongoOptions().AllowDiskUse {
+		opts.SetAllowDiskUse(true)
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline, opts)
+	return cursor, str, err
+}
+
+// GetMongoDriverRows 使用官方驱动执行查询并读取全部数据，服务端不支持$densify/$fill时查询后填充
+func (zql *Zql) GetMongoDriverRows(ctx context.Context, db *mongo.Database, subTname string) ([]map[string]interface{}, error) {
+	cursor, err := zql.GetMongoDriverQuery(ctx, db, subTname)
+	if err != nil {
+		return nil, err
+	}
+	list, err := mongoDriverRows(ctx, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return zql.MongoFillRows(list)
+}
+
+// 读取游标当前行，时间转为time.Time，与mgo的结果一致
+func mongoDriverRow(cursor *mongo.Cursor) (map[string]interface{}, error) {
+	row := bson.M{}
+	if err := cursor.Decode(&row); err != nil {
+		return nil, err
+	}
+	for key, val := range row {
+		if t, ok := val.(primitive.DateTime); ok {
+			row[key] = t.Time()
+		}
+	}
+	return row, nil
+}
+
+// 读取游标中的全部数据并关闭游标
+func mongoDriverRows(ctx context.Context, cursor *mongo.Cursor) ([]map[string]interface{}, error) {
+	defer cursor.Close(ctx)
+	list := make([]map[string]interface{}, 0)
+	for cursor.Next(ctx) {
+		row, err := mongoDriverRow(cursor)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, row)
+	}
+	return list, cursor.Err()
+}
+
+// MongoDriverFilter 查询条件，不需要数据库连接
+func (zql *Zql) MongoDriverFilter() (bson.M, error) {
+	if zql.Where == "" {
+		return bson.M{}, nil
+	}
+	return handleWhereToMap(zql.Where, zql.mongoOptions())
+}
+
+// MongoDriverFind 普通查询的条件和查询选项(字段、排序、分页)，不需要数据库连接
+func (zql *Zql) MongoDriverFind() (bson.M, *options.FindOptions, error) {
+	find, err := zql.MongoFind()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := options.Find()
+	if find.Projection != nil {
+		opts.SetProjection(find.Projection)
+	}
+	if len(find.Sort) > 0 {
+		opts.SetSort(find.Sort)
+	}
+	if find.Skip > 0 {
+		opts.SetSkip(int64(find.Skip))
+	}
+	if find.Limit > 0 {
+		opts.SetLimit(int64(find.Limit))
+	}
+	return find.Filter, opts, nil
+}
+
+// MongoDriverPipeline 分组查询的pipeline，不需要数据库连接
+func (zql *Zql) MongoDriverPipeline() (mongo.Pipeline, error) {
+	return zql.mongoDriverPipeline("")
+}
+
+func (zql *Zql) mongoDriverPipeline(subTname string) (mongo.Pipeline, error) {
+	groupBson, err := zql.mongoPipeline(subTname)
+	if err != nil {
+		return nil, err
+	}
+	pipeline := make(mongo.Pipeline, 0, len(groupBson))
+	// 每个阶段只有一个key，直接转为bson.D
+	for _, v := range groupBson {
+		for key, val := range v {
+			pipeline = append(pipeline, bson.D{{Key: key, Value: val}})
+		}
+	}
+	return pipeline, nil
+}
@@ -0,0 +1,258 @@
+package zql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 扫描文档数超过返回数的倍数时提示
+const mongoExplainExaminedRatio = 10
+
+// MongoExplainResult mongodb 执行计划摘要
+type MongoExplainResult struct {
+	Query        string   // mongo shell写法的查询语句
+	WinningPlan  string   // 最终执行计划，从外到内 LIMIT <- FETCH <- IXSCAN
+	Indexes      []string // 使用的索引名
+	KeysExamined int64    // 扫描索引键数
+	DocsExamined int64    // 扫描文档数
+	Returned     int64    // 返回文档数
+	Warnings     []string // COLLSCAN、内存排序等提示
+	IndexAdvice  bson.D   // 按ESR规则(等值、排序、范围)建议的复合索引
+	IndexShell   string   // 建议索引的shell写法，没有建议时为空
+	Raw          bson.M   // explain 原始结果
+}
+
+// MongoExplain 使用官方驱动对生成的查询执行explain，返回执行计划摘要和索引建议，subTname为表后缀
+func (zql *Zql) MongoExplain(ctx context.Context, db *mongo.Database, subTname string) (*MongoExplainResult, error) {
+	if zql.Select == "" || zql.From == "" {
+		return nil, errors.New("Query string does not exist 'select|from'")
+	}
+	collection := zql.mongoCollection(subTname)
+	str, err := zql.MongoShell(subTname)
+	if err != nil {
+		return nil, err
+	}
+	var cmd bson.D
+	if !zql.MongoUseAggregate() {
+		find, err := zql.MongoFind()
+		if err != nil {
+			return nil, err
+		}
+		cmd = bson.D{{Key: "find", Value: collection}, {Key: "filter", Value: find.Filter}}
+		if find.Projection != nil {
+			cmd = append(cmd, bson.E{Key: "projection", Value: find.Projection})
+		}
+		if len(find.Sort) > 0 {
+			cmd = append(cmd, bson.E{Key: "sort", Value: find.Sort})
+		}
+		if find.Skip > 0 {
+			cmd = append(cmd, bson.E{Key: "skip", Value: int64(find.Skip)})
+		}
+		if find.Limit > 0 {
+			cmd = append(cmd, bson.E{Key: "limit", Value: int64(find.Limit)})
+		}
+	} else {
+		pipeline, err := zql.mongoDriverPipeline(subTname)
+		if err != nil {
+			return nil, err
+		}
+		cmd = bson.D{{Key: "aggregate", Value: collection}, {Key: "pipeline", Value: pipeline}, {Key: "cursor", Value: bson.D{}}}
+		if zql.mongoOptions().AllowDiskUse {
+			cmd = append(cmd, bson.E{Key: "allowDiskUse", Value: true})
+		}
+	}
+	raw := bson.M{}
+	err = db.RunCommand(ctx, bson.D{{Key: "explain", Value: cmd}, {Key: "verbosity", Value: "executionStats"}}).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+	result := mongoExplainSummary(raw)
+	result.Query = str
+	if err = zql.mongoExplainAdvice(result, collection); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 解析explain结果，兼容find、aggregate和新版本查询引擎的结构
+func mongoExplainSummary(raw bson.M) *MongoExplainResult {
+	result := &MongoExplainResult{Raw: raw}
+	planner, stats := raw["queryPlanner"], raw["executionStats"]
+	// aggregate 的执行计划在第一个 $cursor 阶段中
+	if stages, ok := raw["stages"].(bson.A); ok && len(stages) > 0 {
+		if first, ok := stages[0].(bson.M); ok {
+			if cursor, ok := first["$cursor"].(bson.M); ok {
+				planner, stats = cursor["queryPlanner"], cursor["executionStats"]
+			}
+		}
+	}
+	if p, ok := planner.(bson.M); ok {
+		plan, _ := p["winningPlan"].(bson.M)
+		// 新版本查询引擎 winningPlan.queryPlan
+		if queryPlan, ok := plan["queryPlan"].(bson.M); ok {
+			plan = queryPlan
+		}
+		stages := make([]string, 0)
+		mongoExplainStages(plan, &stages, result)
+		result.WinningPlan = strings.Join(stages, " <- ")
+	}
+	if s, ok := stats.(bson.M); ok {
+		result.KeysExamined = explainInt(s["totalKeysExamined"])
+		result.DocsExamined = explainInt(s["totalDocsExamined"])
+		result.Returned = explainInt(s["nReturned"])
+	}
+	if result.Returned > 0 && result.DocsExamined > result.Returned*mongoExplainExaminedRatio {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("Examined %d documents to return %d", result.DocsExamined, result.Returned))
+	}
+	return result
+}
+
+// 遍历执行计划阶段，记录使用的索引和需要提示的阶段
+func mongoExplainStages(plan bson.M, stages *[]string, result *MongoExplainResult) {
+	if plan == nil {
+		return
+	}
+	stage, _ := plan["stage"].(string)
+	if stage != "" {
+		*stages = append(*stages, stage)
+	}
+	switch stage {
+	case "COLLSCAN":
+		result.Warnings = append(result.Warnings, "COLLSCAN: query scans the whole collection")
+	case "SORT":
+		result.Warnings = append(result.Warnings, "SORT: query sorts documents in memory")
+	}
+	if name, ok := plan["indexName"].(string); ok && name != "" {
+		result.Indexes = append(result.Indexes, name)
+	}
+	if input, ok := plan["inputStage"].(bson.M); ok {
+		mongoExplainStages(input, stages, result)
+	}
+	if inputs, ok := plan["inputStages"].(bson.A); ok {
+		for _, v := range inputs {
+			if input, ok := v.(bson.M); ok {
+				mongoExplainStages(input, stages, result)
+			}
+		}
+	}
+}
+
+// explain 中的数字可能是int32、int64或float64
+func explainInt(val interface{}) int64 {
+	num, _ := memoryNumber(val)
+	return int64(num)
+}
+
+// 写入索引建议
+func (zql *Zql) mongoExplainAdvice(result *MongoExplainResult, collection string) error {
+	advice, err := zql.MongoIndexAdvice()
+	if err != nil {
+		return err
+	}
+	result.IndexAdvice = advice
+	if len(advice) > 0 {
+		result.IndexShell = mongoShellCollection(collection) + ".createIndex(" + mongoShellValue(advice) + ")"
+	}
+	return nil
+}
+
+// MongoIndexAdvice 按ESR规则建议复合索引：等值条件字段、排序字段、范围条件字段，不需要数据库连接
+// 只使用and连接的条件，or分组中的条件不参与
+func (zql *Zql) MongoIndexAdvice() (bson.D, error) {
+	base, joins, err := zql.mongoJoinBase()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := base.WhereTree()
+	if err != nil {
+		return nil, err
+	}
+	opts := zql.mongoOptions()
+	equality, ranges := make([]string, 0), make([]string, 0)
+	var walk func(node *WhereNode)
+	walk = func(node *WhereNode) {
+		if node == nil {
+			return
+		}
+		if !node.IsLeaf() {
+			if node.Op == "and" {
+				for _, v := range node.Children {
+					walk(v)
+				}
+			}
+			return
+		}
+		field := node.Field
+		if field == "time" {
+			field = opts.TimeField
+		}
+		// 关联表字段不在主表索引中
+		for _, join := range joins {
+			if strings.HasPrefix(field, join.alias+".") {
+				return
+			}
+		}
+		switch node.Exp {
+		case "=", "in":
+			equality = append(equality, field)
+		case ">", ">=", "<", "<=":
+			ranges = append(ranges, field)
+		case "like":
+			// 只有前缀匹配可以使用索引
+			val := strings.Trim(node.Value, "'")
+			if val != "" && val[0] != '%' && val[0] != '_' {
+				ranges = append(ranges, field)
+			}
+		}
+	}
+	walk(tree)
+	advice := make(bson.D, 0)
+	exists := make(map[string]bool)
+	add := func(field string, order int) {
+		if !exists[field] {
+			exists[field] = true
+			advice = append(advice, bson.E{Key: field, Value: order})
+		}
+	}
+	for _, v := range equality {
+		add(v, 1)
+	}
+	// aggregate 的排序作用于分组或投影后的结果，不参与索引
+	if !zql.MongoUseAggregate() {
+		find, err := zql.MongoFind()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range find.Sort {
+			if v.Key == "$natural" {
+				continue
+			}
+			// 排序方向可能是各种数字类型
+			order := 1
+			switch val := v.Value.(type) {
+			case int:
+				order = val
+			case int32:
+				order = int(val)
+			case int64:
+				order = int(val)
+			case float64:
+				order = int(val)
+			}
+			if order < 0 {
+				add(v.Key, -1)
+			} else {
+				add(v.Key, 1)
+			}
+		}
+	}
+	for _, v := range ranges {
+		add(v, 1)
+	}
+	return advice, nil
+}
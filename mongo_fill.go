@@ -0,0 +1,249 @@
+package zql
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// 时间间隔单位对应$densify的unit
+var mongoDensifyUnits = map[string]string{
+	"s":  "second",
+	"m":  "minute",
+	"h":  "hour",
+	"d":  "day",
+	"w":  "week",
+	"mo": "month",
+	"q":  "quarter",
+	"y":  "year",
+}
+
+// 是否需要填充空的时间分组
+func (zql *Zql) mongoNeedFill() bool {
+	fill := zql.ParseFill()
+	if fill == "" || fill == "none" {
+		return false
+	}
+	_, interval := zql.ParseGroupBy()
+	return interval != ""
+}
+
+// 服务端不支持$densify/$fill时，查询后由MongoFillRows填充
+func (zql *Zql) mongoPostFill() bool {
+	return zql.mongoNeedFill() && !zql.mongoOptions().versionAtLeast(5, 3)
+}
+
+// 填充空分组的$densify和$fill阶段，服务端不支持时返回空
+func (zql *Zql) mongoFillPipeline(interval string) ([]bson.M, error) {
+	if !zql.mongoNeedFill() || zql.mongoPostFill() {
+		return nil, nil
+	}
+	num, unit, err := splitInterval(interval)
+	if err != nil {
+		return nil, errors.New("Query keywords 'group by' error")
+	}
+	var bounds interface{} = "full"
+	if start, end, ok := zql.mongoFillBounds(interval); ok {
+		bounds = []interface{}{start, end}
+	}
+	densify := bson.M{"field": "_id", "range": bson.M{"step": num, "unit": mongoDensifyUnits[unit], "bounds": bounds}}
+	// 补充的文档只有_id，其它字段按fill方式填充
+	output := bson.M{}
+	var method bson.M
+	switch fill := zql.ParseFill(); fill {
+	case "null":
+		method = bson.M{"value": nil}
+	case "previous":
+		method = bson.M{"method": "locf"}
+	case "linear":
+		method = bson.M{"method": "linear"}
+	default:
+		val, err := mongoFillValue(fill)
+		if err != nil {
+			return nil, err
+		}
+		method = bson.M{"value": val}
+	}
+	for _, name := range zql.mongoFillFields() {
+		output[name] = method
+	}
+	pipeline := []bson.M{bson.M{"$densify": densify}}
+	if len(output) > 0 {
+		pipeline = append(pipeline, bson.M{"$fill": bson.M{"sortBy": bson.D{{Key: "_id", Value: 1}}, "output": output}})
+	}
+	return pipeline, nil
+}
+
+// fill(数字)的填充值
+func mongoFillValue(fill string) (interface{}, error) {
+	val, err := memoryLiteral(fill)
+	if _, ok := val.(float64); !ok || err != nil {
+		return nil, errors.New("Query keywords 'fill' error:" + fill)
+	}
+	return val, nil
+}
+
+// 需要填充的字段，即select中的各列
+func (zql *Zql) mongoFillFields() []string {
+	fields, _ := zql.ParseSelect()
+	names := make([]string, 0, len(fields))
+	for _, v := range fields {
+		if v.Field == "*" && v.Func == "" {
+			continue
+		}
+		names = append(names, v.Name())
+	}
+	return names
+}
+
+// 从where中的时间条件得到填充范围，开始时间按分组间隔对齐，没有结束时间时为当前时间
+func (zql *Zql) mongoFillBounds(interval string) (time.Time, time.Time, bool) {
+	var start, end time.Time
+	tree, err := zql.WhereTree()
+	if err != nil || tree == nil {
+		return start, end, false
+	}
+	timeField := zql.mongoOptions().TimeField
+	nodes := []*WhereNode{tree}
+	if tree.Op == "and" {
+		nodes = tree.Children
+	}
+	for _, v := range nodes {
+		if !v.IsLeaf() || (v.Field != "time" && v.Field != timeField) {
+			continue
+		}
+		val, err := memoryLiteral(v.Value)
+		if err != nil {
+			continue
+		}
+		t, ok := val.(time.Time)
+		if !ok {
+			continue
+		}
+		switch v.Exp {
+		case ">", ">=":
+			start = t
+		case "<", "<=":
+			end = t
+		}
+	}
+	if start.IsZero() {
+		return start, end, false
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	start, err = intervalStart(start, interval)
+	if err != nil {
+		return start, end, false
+	}
+	return start.UTC(), end.UTC(), true
+}
+
+// MongoFillRows 服务端不支持$densify/$fill时，按fill方式补充空的时间分组，并处理排序和分页
+// mgo查询和MongoResultSet会自动调用，官方驱动查询可读取全部数据后调用
+func (zql *Zql) MongoFillRows(rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	if !zql.mongoPostFill() {
+		return rows, nil
+	}
+	_, interval := zql.ParseGroupBy()
+	fill := zql.ParseFill()
+	var fillVal interface{}
+	if fill != "null" && fill != "previous" && fill != "linear" {
+		val, err := mongoFillValue(fill)
+		if err != nil {
+			return nil, err
+		}
+		fillVal = val
+	}
+	// 按分组开始时间索引已有数据
+	exists := make(map[int64]map[string]interface{})
+	var minTime, maxTime time.Time
+	for _, row := range rows {
+		t, ok := memoryTime(row["_id"])
+		if !ok {
+			return nil, errors.New("Group '_id' is not a time value")
+		}
+		t = t.UTC()
+		exists[t.Unix()] = row
+		if minTime.IsZero() || t.Before(minTime) {
+			minTime = t
+		}
+		if maxTime.IsZero() || t.After(maxTime) {
+			maxTime = t
+		}
+	}
+	start, end, ok := zql.mongoFillBounds(interval)
+	if !ok {
+		if len(rows) == 0 {
+			return rows, nil
+		}
+		start, end = minTime, intervalNext(maxTime, interval)
+	}
+	fields := zql.mongoFillFields()
+	list := make([]map[string]interface{}, 0)
+	filled := make([]bool, 0)
+	for t := start; t.Before(end); t = intervalNext(t, interval) {
+		if row, ok := exists[t.Unix()]; ok {
+			list = append(list, row)
+			filled = append(filled, false)
+			continue
+		}
+		row := map[string]interface{}{"_id": t, "time": t}
+		for _, name := range fields {
+			row[name] = fillVal
+		}
+		list = append(list, row)
+		filled = append(filled, true)
+	}
+	switch fill {
+	case "previous":
+		for k := 1; k < len(list); k++ {
+			if filled[k] {
+				for _, name := range fields {
+					list[k][name] = list[k-1][name]
+				}
+			}
+		}
+	case "linear":
+		for _, name := range fields {
+			mongoFillLinear(list, filled, name)
+		}
+	}
+	// 排序和分页
+	memorySort(list, zql.ParseOrderBy(), nil)
+	skip, limit, err := zql.ParseLimit()
+	if err != nil {
+		return nil, errors.New("Query keywords 'limit' error")
+	}
+	if skip >= len(list) {
+		return list[:0], nil
+	}
+	list = list[skip:]
+	if limit >= 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// 按前后两个有值的分组线性插值，两端没有数据的分组为null
+func mongoFillLinear(list []map[string]interface{}, filled []bool, name string) {
+	prev := -1
+	for k := range list {
+		if filled[k] {
+			continue
+		}
+		if _, ok := memoryNumber(list[k][name]); !ok {
+			continue
+		}
+		if prev >= 0 && k-prev > 1 {
+			a, _ := memoryNumber(list[prev][name])
+			b, _ := memoryNumber(list[k][name])
+			for i := prev + 1; i < k; i++ {
+				list[i][name] = a + (b-a)*float64(i-prev)/float64(k-prev)
+			}
+		}
+		prev = k
+	}
+}
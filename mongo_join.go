@@ -0,0 +1,212 @@
+package zql
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// join 关键词
+var mongoJoinReg = regexp.MustCompile(`\s+(left\s+join|inner\s+join|join)\s+`)
+
+// mongodb 关联查询 from events e left join users u on e.uid = u._id
+type mongoJoin struct {
+	table      string   // 关联表名，不包含前缀
+	alias      string   // 关联表别名，关联数据保存在此字段
+	left       bool     // left join 时保留没有关联数据的文档
+	conditions []string // on 条件，已去掉主表别名
+}
+
+// 是否是关联查询
+func (zql *Zql) isMongoJoin() bool {
+	return mongoJoinReg.MatchString(zql.From)
+}
+
+// 拆分from中的join，返回去掉主表别名后的查询对象
+func (zql *Zql) mongoJoinBase() (*Zql, []*mongoJoin, error) {
+	if !zql.isMongoJoin() {
+		return zql, nil, nil
+	}
+	from := strings.TrimSpace(zql.From)
+	locs := mongoJoinReg.FindAllStringSubmatchIndex(from, -1)
+	// 主表
+	baseList := strings.Fields(from[:locs[0][0]])
+	if len(baseList) == 0 || len(baseList) > 2 {
+		return nil, nil, errors.New("Query keywords 'from' error:" + from)
+	}
+	baseAlias := ""
+	if len(baseList) == 2 {
+		baseAlias = baseList[1]
+	}
+	base := *zql
+	base.From = baseList[0]
+	base.Select = stripMongoAlias(zql.Select, baseAlias)
+	base.Where = stripMongoAlias(zql.Where, baseAlias)
+	base.GroupBy = stripMongoAlias(zql.GroupBy, baseAlias)
+	base.OrderBy = stripMongoAlias(zql.OrderBy, baseAlias)
+	// 关联表
+	joins := make([]*mongoJoin, 0)
+	for k, loc := range locs {
+		end := len(from)
+		if k+1 < len(locs) {
+			end = locs[k+1][0]
+		}
+		part := from[loc[1]:end]
+		onIndex := strings.Index(part, " on ")
+		if onIndex == -1 {
+			return nil, nil, errors.New("Query keywords 'join' needs 'on':" + part)
+		}
+		tableList := strings.Fields(part[:onIndex])
+		if len(tableList) == 0 || len(tableList) > 2 {
+			return nil, nil, errors.New("Query keywords 'join' error:" + part)
+		}
+		join := &mongoJoin{
+			table: tableList[0],
+			alias: tableList[0],
+			left:  strings.Index(from[loc[2]:loc[3]], "left") == 0,
+		}
+		if len(tableList) == 2 {
+			join.alias = tableList[1]
+		}
+		for _, v := range strings.Split(part[onIndex+4:], " and ") {
+			v = strings.TrimSpace(stripMongoAlias(v, baseAlias))
+			if v == "" {
+				return nil, nil, errors.New("Query keywords 'on' error:" + part)
+			}
+			join.conditions = append(join.conditions, v)
+		}
+		joins = append(joins, join)
+	}
+	return &base, joins, nil
+}
+
+// 去掉字段前的主表别名 e.uid -> uid，引号中的内容不处理
+func stripMongoAlias(str, alias string) string {
+	if alias == "" || str == "" {
+		return str
+	}
+	prefix := alias + "."
+	out := ""
+	quote := false
+	for k := 0; k < len(str); k++ {
+		c := str[k]
+		if c == '\'' {
+			quote = !quote
+		}
+		if !quote && strings.HasPrefix(str[k:], prefix) && (k == 0 || !isMongoFieldChar(str[k-1])) {
+			k += len(prefix) - 1
+			continue
+		}
+		out += string(c)
+	}
+	return out
+}
+
+func isMongoFieldChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// 关联查询的pipeline，$lookup和$unwind放在最前面，条件不涉及关联表时先执行$match
+func (zql *Zql) mongoJoinPipeline(joins []*mongoJoin, subTname string) ([]bson.M, error) {
+	lookups := make([]bson.M, 0)
+	for _, join := range joins {
+		stages, err := zql.mongoLookup(join, subTname)
+		if err != nil {
+			return nil, err
+		}
+		lookups = append(lookups, stages...)
+	}
+	pipeline, err := zql.mongoPipeline(subTname)
+	if err != nil {
+		return nil, err
+	}
+	// 条件中是否使用了关联表字段
+	matchFirst := len(pipeline) > 0 && pipeline[0]["$match"] != nil
+	for _, join := range joins {
+		if stripMongoAlias(zql.Where, join.alias) != zql.Where {
+			matchFirst = false
+		}
+	}
+	if matchFirst {
+		return append(append([]bson.M{pipeline[0]}, lookups...), pipeline[1:]...), nil
+	}
+	return append(lookups, pipeline...), nil
+}
+
+// 单个关联表的$lookup和$unwind
+func (zql *Zql) mongoLookup(join *mongoJoin, subTname string) ([]bson.M, error) {
+	from := zql.MongodbTableName(zql.Prefix+join.table, subTname)
+	prefix := join.alias + "."
+	var lookup bson.M
+	// 只有一个字段相等条件时使用localField/foreignField
+	if len(join.conditions) == 1 {
+		list := strings.Fields(join.conditions[0])
+		if len(list) == 3 && list[1] == "=" {
+			local, foreign := list[0], list[2]
+			if strings.HasPrefix(local, prefix) {
+				local, foreign = foreign, local
+			}
+			if strings.HasPrefix(foreign, prefix) && !strings.HasPrefix(local, prefix) && mongoLookupField(local) {
+				lookup = bson.M{
+					"from":         from,
+					"localField":   local,
+					"foreignField": foreign[len(prefix):],
+					"as":           join.alias,
+				}
+			}
+		}
+	}
+	// 其它条件使用pipeline写法
+	if lookup == nil {
+		let := bson.M{}
+		exprs := make([]interface{}, 0)
+		for _, v := range join.conditions {
+			expression, err := expressionOneWhere(v)
+			if err != nil {
+				return nil, err
+			}
+			op := expression[1]
+			switch op {
+			case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			default:
+				return nil, errors.New("Query keywords 'on' operator error:" + v)
+			}
+			left := zql.mongoLookupValue(expression[0], prefix, let)
+			right := zql.mongoLookupValue(expression[2], prefix, let)
+			exprs = append(exprs, bson.M{op: []interface{}{left, right}})
+		}
+		lookup = bson.M{
+			"from":     from,
+			"let":      let,
+			"pipeline": []bson.M{bson.M{"$match": bson.M{"$expr": bson.M{"$and": exprs}}}},
+			"as":       join.alias,
+		}
+	}
+	unwind := bson.M{"path": "$" + join.alias, "preserveNullAndEmptyArrays": join.left}
+	return []bson.M{bson.M{"$lookup": lookup}, bson.M{"$unwind": unwind}}, nil
+}
+
+// 是否主表字段，true、false、null为常量
+func mongoLookupField(str string) bool {
+	return mongoFieldReg.MatchString(str) && str != "true" && str != "false" && str != "null"
+}
+
+// on 条件中的值，关联表字段为$field，主表字段通过let变量引用，其它为常量
+func (zql *Zql) mongoLookupValue(str, prefix string, let bson.M) interface{} {
+	str = strings.TrimSpace(str)
+	if strings.HasPrefix(str, prefix) {
+		return "$" + str[len(prefix):]
+	}
+	if mongoLookupField(str) {
+		name := "v_" + strings.Replace(str, ".", "_", -1)
+		let[name] = "$" + str
+		return "$$" + name
+	}
+	val, err := mongoValue("", str, zql.mongoOptions())
+	if err != nil {
+		return str
+	}
+	return val
+}
@@ -0,0 +1,126 @@
+package zql
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// 时间字段存储类型
+const (
+	MongoTimeUnix   = "unix"    // 秒级时间戳，默认
+	MongoTimeUnixMs = "unix_ms" // 毫秒时间戳
+	MongoTimeDate   = "date"    // BSON Date (ISODate)
+)
+
+// 没有order by时的默认排序
+const (
+	MongoSortNone    = "none"    // 不排序，默认
+	MongoSortNatural = "natural" // 按存储顺序 $natural
+)
+
+// MongoOptions mongodb 表的查询配置
+type MongoOptions struct {
+	TimeField string // 时间字段，条件中的time对应此字段，默认datetime
+	TimeType  string // 时间字段存储类型，决定时间比较和time()分组的写法
+	// 字段存储类型 int|float|string|bool|objectid|date，条件值转为此类型
+	Schema map[string]string
+	// 没有order by时的排序：none、natural或字段名，字段名前加"-"为倒序，time对应时间字段
+	DefaultSort string
+	// aggregate 允许使用磁盘临时文件，避免排序超过内存限制
+	AllowDiskUse bool
+	// 服务端版本，例如5.3，5.3及以上使用$densify/$fill填充空的时间分组，否则查询后填充
+	ServerVersion string
+}
+
+// 默认配置
+var defaultMongoOptions = &MongoOptions{TimeField: "datetime", TimeType: MongoTimeUnix}
+
+// 按表名保存的配置
+var (
+	mongoOptionsMap  = make(map[string]*MongoOptions)
+	mongoOptionsLock sync.RWMutex
+)
+
+// SetMongoOptions 设置表的查询配置，tname为Prefix+From，不包含subTname
+func SetMongoOptions(tname string, opts *MongoOptions) {
+	mongoOptionsLock.Lock()
+	defer mongoOptionsLock.Unlock()
+	if opts == nil {
+		delete(mongoOptionsMap, tname)
+		return
+	}
+	mongoOptionsMap[tname] = opts
+}
+
+// 当前查询使用的配置，优先使用zql.Mongo，其次是SetMongoOptions设置的配置
+func (zql *Zql) mongoOptions() *MongoOptions {
+	opts := zql.Mongo
+	if opts == nil {
+		mongoOptionsLock.RLock()
+		opts = mongoOptionsMap[zql.Prefix+zql.From]
+		mongoOptionsLock.RUnlock()
+	}
+	if opts == nil {
+		return defaultMongoOptions
+	}
+	// 未设置的项使用默认值
+	merged := *opts
+	if merged.TimeField == "" {
+		merged.TimeField = defaultMongoOptions.TimeField
+	}
+	if merged.TimeType == "" {
+		merged.TimeType = defaultMongoOptions.TimeType
+	}
+	return &merged
+}
+
+// 没有order by时的排序
+func (opts *MongoOptions) defaultSort() bson.D {
+	field := strings.TrimSpace(opts.DefaultSort)
+	switch field {
+	case "", MongoSortNone:
+		return nil
+	case MongoSortNatural:
+		return bson.D{{Key: "$natural", Value: 1}}
+	}
+	order := 1
+	if strings.HasPrefix(field, "-") {
+		field, order = field[1:], -1
+	}
+	if field == "time" {
+		field = opts.TimeField
+	}
+	return bson.D{{Key: field, Value: order}}
+}
+
+// 服务端版本是否不低于major.minor
+func (opts *MongoOptions) versionAtLeast(major, minor int) bool {
+	list := strings.Split(strings.TrimSpace(opts.ServerVersion), ".")
+	if len(list) < 1 || list[0] == "" {
+		return false
+	}
+	ver := make([]int, 2)
+	for k := 0; k < len(list) && k < 2; k++ {
+		num, err := strconv.Atoi(list[k])
+		if err != nil {
+			return false
+		}
+		ver[k] = num
+	}
+	return ver[0] > major || (ver[0] == major && ver[1] >= minor)
+}
+
+// 按时间字段的存储类型转换时间值
+func (opts *MongoOptions) timeValue(t time.Time) interface{} {
+	switch opts.TimeType {
+	case MongoTimeDate:
+		return t
+	case MongoTimeUnixMs:
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	return t.Unix()
+}
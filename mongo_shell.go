@@ -0,0 +1,168 @@
+package zql
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 可以直接用 db.name 访问的表名
+var shellCollectionReg = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// MongoShell 返回mongo shell写法的查询语句，不需要数据库连接
+// db.coll.find({...}, {...}).sort({...}).skip(10).limit(10) 或 db.coll.aggregate([...])
+func (zql *Zql) MongoShell(subTname string) (string, error) {
+	collection := zql.mongoCollection(subTname)
+	if !zql.MongoUseAggregate() {
+		find, err := zql.MongoFind()
+		if err != nil {
+			return "", err
+		}
+		return find.shell(collection), nil
+	}
+	pipeline, err := zql.mongoPipeline(subTname)
+	if err != nil {
+		return "", err
+	}
+	str := mongoShellAggregate(collection, pipeline, zql.mongoOptions().AllowDiskUse)
+	if zql.mongoPostFill() {
+		str += zql.mongoPostFillNote()
+	}
+	return str, nil
+}
+
+// 查询后填充时，填充和分页不在pipeline中，以注释标明
+func (zql *Zql) mongoPostFillNote() string {
+	note := "fill(" + zql.ParseFill() + ")"
+	skip, limit, err := zql.ParseLimit()
+	if err == nil && skip > 0 {
+		note += ", skip " + strconv.Itoa(skip)
+	}
+	if err == nil && limit >= 0 {
+		note += ", limit " + strconv.Itoa(limit)
+	}
+	return " /* " + note + " applied by MongoFillRows */"
+}
+
+// mgo排序写法，倒序字段前加"-"
+func (find *MongoFindQuery) sortFields() []string {
+	fields := make([]string, 0, len(find.Sort))
+	for _, v := range find.Sort {
+		if v.Value == -1 {
+			fields = append(fields, "-"+v.Key)
+		} else {
+			fields = append(fields, v.Key)
+		}
+	}
+	return fields
+}
+
+// 普通查询的shell写法
+func (find *MongoFindQuery) shell(collection string) string {
+	str := mongoShellCollection(collection) + ".find(" + mongoShellValue(find.Filter)
+	if find.Projection != nil {
+		str += ", " + mongoShellValue(find.Projection)
+	}
+	str += ")"
+	if len(find.Sort) > 0 {
+		str += ".sort(" + mongoShellValue(find.Sort) + ")"
+	}
+	if find.Skip > 0 {
+		str += fmt.Sprintf(".skip(%d)", find.Skip)
+	}
+	if find.Limit > 0 {
+		str += fmt.Sprintf(".limit(%d)", find.Limit)
+	}
+	return str
+}
+
+// 分组查询的shell写法
+func mongoShellAggregate(collection string, pipeline []bson.M, allowDiskUse bool) string {
+	str := mongoShellCollection(collection) + ".aggregate(" + mongoShellValue(pipeline)
+	if allowDiskUse {
+		str += ", {allowDiskUse: true}"
+	}
+	return str + ")"
+}
+
+func mongoShellCollection(collection string) string {
+	if shellCollectionReg.MatchString(collection) {
+		return "db." + collection
+	}
+	js, _ := json.Marshal(collection)
+	return "db.getCollection(" + string(js) + ")"
+}
+
+// 值转为shell写法，map按key排序
+func mongoShellValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case bson.M:
+		return mongoShellValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		list := make([]string, 0, len(keys))
+		for _, key := range keys {
+			list = append(list, mongoShellKey(key)+": "+mongoShellValue(v[key]))
+		}
+		return "{" + strings.Join(list, ", ") + "}"
+	case bson.D:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, mongoShellKey(e.Key)+": "+mongoShellValue(e.Value))
+		}
+		return "{" + strings.Join(list, ", ") + "}"
+	case []bson.M:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, mongoShellValue(e))
+		}
+		return "[" + strings.Join(list, ", ") + "]"
+	case bson.A:
+		return mongoShellValue([]interface{}(v))
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, mongoShellValue(e))
+		}
+		return "[" + strings.Join(list, ", ") + "]"
+	case []string:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, mongoShellValue(e))
+		}
+		return "[" + strings.Join(list, ", ") + "]"
+	case time.Time:
+		return `ISODate("` + v.UTC().Format("2006-01-02T15:04:05.000Z") + `")`
+	case primitive.ObjectID:
+		return `ObjectId("` + v.Hex() + `")`
+	case primitive.Regex:
+		return "/" + strings.Replace(v.Pattern, "/", `\/`, -1) + "/" + v.Options
+	}
+	js, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(js)
+}
+
+// key 中包含特殊字符或$开头时加引号
+func mongoShellKey(key string) string {
+	if shellCollectionReg.MatchString(key) {
+		return key
+	}
+	js, _ := json.Marshal(key)
+	return string(js)
+}
@@ -0,0 +1,290 @@
+package zql
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WhereNode where条件树节点，Op为and/or时是分组节点，为空时是单个条件
+type WhereNode struct {
+	Op       string       // and | or
+	Children []*WhereNode // 子条件
+	Field    string       // 字段名
+	Exp      string       // 原始操作符 = != < <= > >= in like ilike regexp =~，全文检索时为函数名
+	Value    string       // 原始值，字符串带单引号
+	Args     []string     // 全文检索函数的参数 match(field, 'text')
+}
+
+// IsLeaf 是否是单个条件
+func (node *WhereNode) IsLeaf() bool {
+	return node.Op == ""
+}
+
+// WhereTree 将where条件解析为条件树，条件写法与mongodb、elasticsearch一致，需用小括号分组
+func (zql *Zql) WhereTree() (*WhereNode, error) {
+	if zql.Where == "" {
+		return nil, nil
+	}
+	return ParseWhere(zql.Where)
+}
+
+// ParseWhere 解析where字符串为条件树
+func ParseWhere(str string) (*WhereNode, error) {
+	str = strings.TrimSpace(str)
+	// 单个全文检索函数
+	if searchFuncReg.MatchString(str) && len(splitTopLevel(str, ' ')) == 1 {
+		return parseWhereLeaf(str)
+	}
+	whereList := whrere(str)
+	// 没有括号分组时整体作为单个条件
+	if len(whereList) == 0 {
+		return parseWhereLeaf(str)
+	}
+	orNode := &WhereNode{Op: "or"}
+	andNode := &WhereNode{Op: "and"}
+	for _, v := range whereList {
+		switch {
+		case v == "and":
+			continue
+		case v == "or":
+			orNode.Children = append(orNode.Children, andNode.simplify())
+			andNode = &WhereNode{Op: "and"}
+		case v == "":
+			return nil, errors.New("Query keywords 'where' error: empty group")
+		case v[:1] == "(":
+			child, err := ParseWhere(v)
+			if err != nil {
+				return nil, err
+			}
+			andNode.Children = append(andNode.Children, child)
+		default:
+			child, err := parseWhereLeaf(v)
+			if err != nil {
+				return nil, err
+			}
+			andNode.Children = append(andNode.Children, child)
+		}
+	}
+	orNode.Children = append(orNode.Children, andNode.simplify())
+	return orNode.simplify(), nil
+}
+
+// 只有一个子条件的分组直接返回子条件
+func (node *WhereNode) simplify() *WhereNode {
+	if !node.IsLeaf() && len(node.Children) == 1 {
+		return node.Children[0]
+	}
+	return node
+}
+
+// 全文检索条件函数
+var searchFuncReg = regexp.MustCompile(`^(match|phrase|term|multi_match|fuzzy|query_string)\s*\((.*)\)$`)
+
+// 解析单个条件
+func parseWhereLeaf(str string) (*WhereNode, error) {
+	if match := searchFuncReg.FindStringSubmatch(str); match != nil {
+		return parseSearchFunc(match[1], match[2])
+	}
+	expression, err := expressionOneWhere(str)
+	if err != nil {
+		return nil, err
+	}
+	list := strings.Fields(str)
+	return &WhereNode{Field: list[0], Exp: list[1], Value: expression[2]}, nil
+}
+
+// 解析全文检索函数，Field为检索字段，Value为检索内容
+// match(field, 'text') phrase(field, 'text') term(field, value) fuzzy(field, 'text', 2)
+// multi_match('text', field1, field2) query_string('text')
+func parseSearchFunc(name, str string) (*WhereNode, error) {
+	args := make([]string, 0)
+	for _, v := range splitTopLevel(str, ',') {
+		if v = strings.TrimSpace(v); v == "" {
+			return nil, errors.New("Search function arguments error:" + name + "(" + str + ")")
+		}
+		args = append(args, v)
+	}
+	node := &WhereNode{Exp: name, Args: args}
+	switch name {
+	case "match", "phrase", "term":
+		if len(args) != 2 {
+			return nil, errors.New("Search function needs (field, value):" + name + "(" + str + ")")
+		}
+		node.Field, node.Value = args[0], args[1]
+	case "fuzzy":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, errors.New("Search function needs (field, value[, fuzziness]):" + name + "(" + str + ")")
+		}
+		node.Field, node.Value = args[0], args[1]
+	case "multi_match":
+		if len(args) < 2 {
+			return nil, errors.New("Search function needs (value, field1, ...):" + name + "(" + str + ")")
+		}
+		node.Value = args[0]
+	case "query_string":
+		if len(args) != 1 {
+			return nil, errors.New("Search function needs (query):" + name + "(" + str + ")")
+		}
+		node.Value = args[0]
+	}
+	return node, nil
+}
+
+// SelectField select中的单个字段
+type SelectField struct {
+	Expr  string // 原始表达式 count(*) as c
+	Func  string // 聚合函数名 count|avg|sum|max|min，elasticsearch高亮为highlight，普通字段为空
+	Field string // 字段名，count(*)时为*
+	Alias string // as 别名
+}
+
+// Name 返回结果中的列名，与mongodb分组结果的命名一致
+func (field *SelectField) Name() string {
+	if field.Alias != "" {
+		return field.Alias
+	}
+	if field.Func == "count" {
+		return "doc_count"
+	}
+	if field.Func == "highlight" {
+		return ElasticHighlightKey
+	}
+	return field.Field
+}
+
+// ParseSelect 拆分select字段列表
+func (zql *Zql) ParseSelect() ([]*SelectField, error) {
+	if zql.Select == "" {
+		return nil, errors.New("Query string does not exist 'select'")
+	}
+	fields := make([]*SelectField, 0)
+	for _, v := range splitTopLevel(zql.Select, ',') {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, errors.New("Field 'select' format error")
+		}
+		field := &SelectField{Expr: v}
+		vval := strings.Split(v, " as ")
+		if len(vval) > 2 {
+			return nil, errors.New("Field 'select' format error:" + v)
+		}
+		if len(vval) == 2 {
+			field.Alias = strings.TrimSpace(vval[1])
+		}
+		vField := strings.TrimSpace(vval[0])
+		if start := strings.Index(vField, "("); start > 0 && vField[len(vField)-1:] == ")" {
+			field.Func = strings.TrimSpace(vField[:start])
+			field.Field = strings.TrimSpace(vField[start+1 : len(vField)-1])
+		} else {
+			field.Field = vField
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// OrderField 排序字段
+type OrderField struct {
+	Field string
+	Desc  bool
+}
+
+// ParseOrderBy 拆分order by，多个排序用","分开
+func (zql *Zql) ParseOrderBy() []*OrderField {
+	orders := make([]*OrderField, 0)
+	if zql.OrderBy == "" {
+		return orders
+	}
+	for _, v := range strings.Split(zql.OrderBy, ",") {
+		sortExp := strings.Fields(v)
+		if len(sortExp) == 0 {
+			continue
+		}
+		orders = append(orders, &OrderField{
+			Field: sortExp[0],
+			Desc:  len(sortExp) == 2 && sortExp[1] == "desc",
+		})
+	}
+	return orders
+}
+
+// ParseLimit 解析limit，返回跳过条数和查询条数，没有limit时查询条数为-1
+func (zql *Zql) ParseLimit() (skip, limit int, err error) {
+	if zql.Limit == "" {
+		return 0, -1, nil
+	}
+	limitList := strings.Split(zql.Limit, ",")
+	if len(limitList) > 2 {
+		return 0, 0, errors.New("Field 'limit' format error")
+	}
+	if len(limitList) == 2 {
+		skip, err = strconv.Atoi(strings.TrimSpace(limitList[0]))
+		if err != nil {
+			return 0, 0, errors.New("Field 'limit' format error:" + err.Error())
+		}
+	}
+	limit, err = strconv.Atoi(strings.TrimSpace(limitList[len(limitList)-1]))
+	if err != nil {
+		return 0, 0, errors.New("Field 'limit' format error:" + err.Error())
+	}
+	return skip, limit, nil
+}
+
+// ParseGroupBy 拆分group by字段，time(5m)按时间分组时返回间隔，time中的其它参数通过ParseTimeGroup获取
+func (zql *Zql) ParseGroupBy() (fields []string, interval string) {
+	fields = make([]string, 0)
+	groupBy := fillReg.ReplaceAllString(zql.GroupBy, "")
+	if groupBy == "" {
+		return fields, ""
+	}
+	for _, v := range splitTopLevel(groupBy, ',') {
+		v = strings.TrimSpace(v)
+		if strings.Index(v, "time(") == 0 && v[len(v)-1:] == ")" {
+			interval, _ = parseTimeGroup(v[5 : len(v)-1])
+			continue
+		}
+		if v != "" {
+			fields = append(fields, v)
+		}
+	}
+	return fields, interval
+}
+
+// ParseTimeGroup 按时间分组的参数，time(5m, field=@timestamp, type=calendar) 返回field和type
+func (zql *Zql) ParseTimeGroup() map[string]string {
+	for _, v := range splitTopLevel(fillReg.ReplaceAllString(zql.GroupBy, ""), ',') {
+		v = strings.TrimSpace(v)
+		if strings.Index(v, "time(") == 0 && v[len(v)-1:] == ")" {
+			_, args := parseTimeGroup(v[5 : len(v)-1])
+			return args
+		}
+	}
+	return make(map[string]string)
+}
+
+// 第一个参数为间隔，其它参数为key=value
+func parseTimeGroup(str string) (string, map[string]string) {
+	args := make(map[string]string)
+	list := splitTopLevel(str, ',')
+	for _, v := range list[1:] {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 {
+			args[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), "'")
+		}
+	}
+	return strings.TrimSpace(list[0]), args
+}
+
+// group by 末尾的 fill(x)
+var fillReg = regexp.MustCompile(`\s*fill\(\s*([^)]*?)\s*\)\s*$`)
+
+// ParseFill 按时间分组时空桶的填充方式 null|previous|linear|none|数字，没有fill时为空
+func (zql *Zql) ParseFill() string {
+	match := fillReg.FindStringSubmatch(zql.GroupBy)
+	if len(match) != 2 {
+		return ""
+	}
+	return match[1]
+}
@@ -0,0 +1,401 @@
+package zql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/olivere/elastic.v3"
+)
+
+// 列类型
+const (
+	ColumnNull   = "null"
+	ColumnString = "string"
+	ColumnInt    = "int"
+	ColumnFloat  = "float"
+	ColumnBool   = "bool"
+	ColumnTime   = "time"
+	ColumnObject = "object"
+	ColumnArray  = "array"
+)
+
+// Column 结果列信息
+type Column struct {
+	Name      string // 列名，有别名时为别名
+	Type      string // 根据前100行中第一个非空值推断的类型，整数和小数混合时为小数，都为空时为null
+	Aggregate string // 来源聚合函数，普通字段为空
+}
+
+// ResultSet 各数据库统一的查询结果，通过Next/Scan逐行读取
+type ResultSet struct {
+	columns []Column
+	next    func() (map[string]interface{}, error) // 返回nil表示没有数据
+	close   func() error
+	peek    []map[string]interface{} // 推断列类型时预读的数据
+	row     map[string]interface{}
+	err     error
+	done    bool // 数据已经读完，游标已释放
+	closed  bool
+}
+
+// 推断列类型时最多预读的行数
+const resultPeekRows = 100
+
+// 创建结果集，预读数据用于推断列信息
+func (zql *Zql) newResultSet(next func() (map[string]interface{}, error), close func() error) (*ResultSet, error) {
+	rs := &ResultSet{next: next, close: close}
+	for len(rs.peek) < resultPeekRows {
+		row, err := next()
+		if err != nil {
+			rs.Close()
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		rs.peek = append(rs.peek, row)
+	}
+	rs.columns = zql.resultColumns(rs.peek)
+	if len(rs.peek) < resultPeekRows {
+		// 数据已经读完，提前释放游标
+		rs.release()
+	}
+	return rs, nil
+}
+
+// 根据select字段和预读的数据生成列信息，类型取第一个不为null的值
+func (zql *Zql) resultColumns(rows []map[string]interface{}) []Column {
+	row := make(map[string]interface{})
+	if len(rows) > 0 {
+		row = rows[0]
+	}
+	columns := make([]Column, 0)
+	exists := make(map[string]bool)
+	fields, _ := zql.ParseSelect()
+	// 按时间分组时数据中包含time列
+	if _, interval := zql.ParseGroupBy(); interval != "" {
+		if _, ok := row["time"]; ok {
+			columns = append(columns, Column{Name: "time"})
+			exists["time"] = true
+		}
+	}
+	for _, v := range fields {
+		if v.Field == "*" && v.Func == "" {
+			continue
+		}
+		if exists[v.Name()] {
+			continue
+		}
+		exists[v.Name()] = true
+		columns = append(columns, Column{Name: v.Name(), Aggregate: v.Func})
+	}
+	// 数据中其它的列，例如select *、_id
+	extra := make([]string, 0)
+	for _, v := range rows {
+		for key := range v {
+			if !exists[key] {
+				exists[key] = true
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		columns = append(columns, Column{Name: key})
+	}
+	for k, v := range columns {
+		columns[k].Type = ColumnNull
+		for _, r := range rows {
+			typ := columnType(r[v.Name])
+			if typ == ColumnNull {
+				continue
+			}
+			// 整数和小数混合时为小数
+			if columns[k].Type == ColumnNull || (columns[k].Type == ColumnInt && typ == ColumnFloat) {
+				columns[k].Type = typ
+			}
+			if typ != ColumnInt {
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// 推断值类型
+func columnType(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return ColumnNull
+	case string:
+		return ColumnString
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return ColumnInt
+	case float32, float64:
+		return ColumnFloat
+	case bool:
+		return ColumnBool
+	case time.Time:
+		return ColumnTime
+	case []interface{}:
+		return ColumnArray
+	case map[string]interface{}:
+		return ColumnObject
+	}
+	return ColumnObject
+}
+
+// Columns 列信息
+func (rs *ResultSet) Columns() []Column {
+	return rs.columns
+}
+
+// Next 读取下一行，没有数据或出错时返回false
+func (rs *ResultSet) Next() bool {
+	if rs.closed || rs.err != nil {
+		return false
+	}
+	if len(rs.peek) > 0 {
+		rs.row, rs.peek = rs.peek[0], rs.peek[1:]
+		return true
+	}
+	if rs.done {
+		rs.row = nil
+		return false
+	}
+	rs.row, rs.err = rs.next()
+	if rs.row == nil {
+		rs.release()
+		return false
+	}
+	return true
+}
+
+// Row 当前行数据
+func (rs *ResultSet) Row() map[string]interface{} {
+	return rs.row
+}
+
+// Scan 按列顺序读取当前行到dest，支持*interface{}、*string、*int64、*int、*float64、*bool、*time.Time
+func (rs *ResultSet) Scan(dest ...interface{}) error {
+	if rs.row == nil {
+		return errors.New("Scan called without calling Next")
+	}
+	if len(dest) != len(rs.columns) {
+		return fmt.Errorf("Expected %d destination arguments in Scan, not %d", len(rs.columns), len(dest))
+	}
+	for k, v := range rs.columns {
+		if err := scanValue(dest[k], rs.row[v.Name]); err != nil {
+			return fmt.Errorf("Scan column '%s' error: %s", v.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// 单个值转换
+func scanValue(dest, val interface{}) error {
+	switch d := dest.(type) {
+	case *interface{}:
+		*d = val
+		return nil
+	case *string:
+		if val == nil {
+			*d = ""
+		} else if s, ok := val.(string); ok {
+			*d = s
+		} else {
+			*d = fmt.Sprint(val)
+		}
+		return nil
+	case *int64, *int:
+		num, ok := memoryNumber(val)
+		if !ok && val != nil {
+			return fmt.Errorf("cannot convert %T to int", val)
+		}
+		if i, ok := d.(*int64); ok {
+			*i = int64(num)
+		} else {
+			*(d.(*int)) = int(num)
+		}
+		return nil
+	case *float64:
+		num, ok := memoryNumber(val)
+		if !ok && val != nil {
+			return fmt.Errorf("cannot convert %T to float64", val)
+		}
+		*d = num
+		return nil
+	case *bool:
+		b, ok := val.(bool)
+		if !ok && val != nil {
+			return fmt.Errorf("cannot convert %T to bool", val)
+		}
+		*d = b
+		return nil
+	case *time.Time:
+		if val == nil {
+			*d = time.Time{}
+			return nil
+		}
+		t, ok := memoryTime(val)
+		if !ok {
+			return fmt.Errorf("cannot convert %T to time.Time", val)
+		}
+		*d = t
+		return nil
+	}
+	return fmt.Errorf("unsupported Scan type %T", dest)
+}
+
+// Err 读取过程中的错误
+func (rs *ResultSet) Err() error {
+	return rs.err
+}
+
+// Close 关闭结果集，释放游标或连接
+func (rs *ResultSet) Close() error {
+	if rs.closed {
+		return nil
+	}
+	rs.closed = true
+	rs.peek = nil
+	return rs.release()
+}
+
+// 释放游标，预读的数据仍然可以读取
+func (rs *ResultSet) release() error {
+	if rs.done {
+		return nil
+	}
+	rs.done = true
+	if rs.close != nil {
+		return rs.close()
+	}
+	return nil
+}
+
+// All 读取剩余全部数据
+func (rs *ResultSet) All() ([]map[string]interface{}, error) {
+	list := make([]map[string]interface{}, 0)
+	for rs.Next() {
+		list = append(list, rs.Row())
+	}
+	return list, rs.Err()
+}
+
+// 已经在内存中的数据
+func sliceRows(rows []map[string]interface{}) func() (map[string]interface{}, error) {
+	return func() (map[string]interface{}, error) {
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}
+}
+
+// MemoryResultSet 在内存数据上执行并返回结果集
+func (zql *Zql) MemoryResultSet(rows []map[string]interface{}) (*ResultSet, error) {
+	list, err := zql.ExecMemory(rows)
+	if err != nil {
+		return nil, err
+	}
+	return zql.newResultSet(sliceRows(list), nil)
+}
+
+// MongoResultSet 执行mongodb查询，通过游标逐行读取
+func (zql *Zql) MongoResultSet(mgoDb *mgo.Database, subTname string) (*ResultSet, error) {
+	mgoQuery, mgoPipe, _, err := zql.GetMongoQueryDetails(mgoDb, subTname)
+	if err != nil {
+		return nil, err
+	}
+	var iter *mgo.Iter
+	if mgoQuery != nil {
+		iter = mgoQuery.Iter()
+	} else if zql.mongoPostFill() {
+		// 需要填充空分组时读取全部数据
+		list := make([]map[string]interface{}, 0)
+		if err = mgoPipe.All(&list); err != nil {
+			return nil, err
+		}
+		if list, err = zql.MongoFillRows(list); err != nil {
+			return nil, err
+		}
+		return zql.newResultSet(sliceRows(list), nil)
+	} else if mgoPipe != nil {
+		iter = mgoPipe.Iter()
+	} else {
+		return zql.newResultSet(sliceRows(nil), nil)
+	}
+	next := func() (map[string]interface{}, error) {
+		row := make(map[string]interface{})
+		if iter.Next(&row) {
+			return row, nil
+		}
+		return nil, iter.Err()
+	}
+	return zql.newResultSet(next, iter.Close)
+}
+
+// MongoDriverResultSet 使用官方驱动执行mongodb查询，通过游标逐行读取
+func (zql *Zql) MongoDriverResultSet(ctx context.Context, db *mongo.Database, subTname string) (*ResultSet, error) {
+	cursor, err := zql.GetMongoDriverQuery(ctx, db, subTname)
+	if err != nil {
+		return nil, err
+	}
+	if zql.mongoPostFill() {
+		// 需要填充空分组时读取全部数据
+		list, err := mongoDriverRows(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+		if list, err = zql.MongoFillRows(list); err != nil {
+			return nil, err
+		}
+		return zql.newResultSet(sliceRows(list), nil)
+	}
+	next := func() (map[string]interface{}, error) {
+		if cursor.Next(ctx) {
+			return mongoDriverRow(cursor)
+		}
+		return nil, cursor.Err()
+	}
+	return zql.newResultSet(next, func() error {
+		return cursor.Close(ctx)
+	})
+}
+
+// ElasticResultSet 执行elasticsearch查询并返回结果集
+func (zql *Zql) ElasticResultSet(client *elastic.Client, dbName string) (*ResultSet, error) {
+	list, err := zql.GetElasticQuery(client, dbName, false)
+	if err != nil {
+		return nil, err
+	}
+	return zql.newResultSet(sliceRows(list), nil)
+}
+
+// ElasticsearchResultSet 使用官方客户端执行elasticsearch查询并返回结果集
+func (zql *Zql) ElasticsearchResultSet(ctx context.Context, transport esapi.Transport, index string) (*ResultSet, error) {
+	list, err := zql.GetElasticsearchQuery(ctx, transport, index)
+	if err != nil {
+		return nil, err
+	}
+	return zql.newResultSet(sliceRows(list), nil)
+}
+
+// InfluxdbResultSet 执行influxdb查询，分块返回时逐块读取
+func (zql *Zql) InfluxdbResultSet(client *http.Client, conf *InfluxdbConfig, suffix string) (*ResultSet, error) {
+	reader, err := zql.queryInfluxdb(client, conf, suffix)
+	if err != nil {
+		return nil, err
+	}
+	return zql.newResultSet(reader.next, reader.close)
+}
@@ -3,6 +3,7 @@ package zql
 import (
 	"errors"
 	"log"
+	"regexp"
 	"sort"
 	"strings"
 )
@@ -21,6 +22,9 @@ type Zql struct {
 	OrderBy string                  // 排序部分
 	Limit   string                  // 查询结果范围
 	Values  *map[string]interface{} // insert 内容部分
+	Mongo   *MongoOptions           // mongodb 表配置，为空时使用SetMongoOptions设置的配置
+	Elastic *ElasticOptions         // elasticsearch 配置，为空时使用SetElasticOptions设置的配置
+	After   string                  // 分页游标，查询末尾的 after '<cursor>'
 }
 
 // select * appname zu_hehe where id = 1 group by time(1m) order by id desc id limit 10,10
@@ -33,8 +37,8 @@ func New(prefix, query string) (myZql *Zql, err error) {
 	if query == "" || len(query) < 7 {
 		return nil, errors.New("query cannot be empty")
 	}
-	// 转小写-去空格
-	query = strings.TrimSpace(strings.ToLower(query))
+	// 转小写-去空格，单引号中的字符串保持原样
+	query = strings.TrimSpace(lowerOutsideQuotes(query))
 	// 创建对象
 	myZql = &Zql{
 		Query:  query,
@@ -67,6 +71,35 @@ func New(prefix, query string) (myZql *Zql, err error) {
 	return myZql, nil
 }
 
+// 单引号以外的部分转小写，正则和比较值区分大小写，引号中的\'不结束字符串
+func lowerOutsideQuotes(query string) string {
+	var buf strings.Builder
+	quote, escape := false, false
+	start := 0
+	for k, v := range query {
+		switch {
+		case escape:
+			escape = false
+		case quote && v == '\\':
+			escape = true
+		case v == '\'':
+			if quote {
+				buf.WriteString(query[start : k+1])
+			} else {
+				buf.WriteString(strings.ToLower(query[start : k+1]))
+			}
+			quote = !quote
+			start = k + 1
+		}
+	}
+	if quote {
+		buf.WriteString(query[start:])
+	} else {
+		buf.WriteString(strings.ToLower(query[start:]))
+	}
+	return buf.String()
+}
+
 // 解析sql各部分函数-insert
 func (zql *Zql) SplitRegZqlInsertString() error {
 	reg := `insert(\s*)into(\s*)(?P<table_name>.*)(\s*)\((?P<keys>.*)\)(\s*)values(\s*)\((?P<values>.*)\)`
@@ -105,8 +138,17 @@ func (zql *Zql) SplitRegZqlInsertString() error {
 	return nil
 }
 
+// 游标分页关键词
+var afterReg = regexp.MustCompile(`\s+after\s+'([^']*)'\s*$`)
+
 // 解析sql各部分函数-select
 func (zql *Zql) SplitZqlSelectString() {
+	query := zql.Query
+	// 游标分页 after '<cursor>' 只能写在最后
+	if loc := afterReg.FindStringSubmatchIndex(query); loc != nil {
+		zql.After = query[loc[2]:loc[3]]
+		query = query[:loc[0]]
+	}
 	var key []int
 	val := map[string]int{
 		"select":   0,
@@ -121,10 +163,10 @@ func (zql *Zql) SplitZqlSelectString() {
 		index := 0
 		switch k {
 		case "select", "from", "appname", "where":
-			index = strings.Index(zql.Query, k)
+			index = strings.Index(query, k)
 			break
 		default:
-			index = strings.LastIndex(zql.Query, k)
+			index = strings.LastIndex(query, k)
 		}
 		if index == -1 {
 			index = 0
@@ -141,12 +183,12 @@ func (zql *Zql) SplitZqlSelectString() {
 			if vv == v {
 				str := ""
 				if k == (len(key) - 1) {
-					str = zql.Query[(v + len(kk)):]
+					str = query[(v + len(kk)):]
 				} else {
 					if key[k+1] == 0 {
-						str = zql.Query[(v + len(kk)):]
+						str = query[(v + len(kk)):]
 					} else {
-						str = zql.Query[(v + len(kk)):key[k+1]]
+						str = query[(v + len(kk)):key[k+1]]
 					}
 				}
 				str = strings.TrimSpace(str) // 去空格
@@ -1,9 +1,21 @@
 package zql
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mgobson "gopkg.in/mgo.v2/bson"
+	"gopkg.in/olivere/elastic.v3"
 )
 
 var sql = "select id as aid appname zu_hehe from zu_hehe where (id=1 or name='123') and time>now()-1h group by time(1m) order by id desc limit 10, 10"
@@ -32,3 +44,1196 @@ func Test_influxdb_query(t *testing.T) {
 	}
 	log.Println(query)
 }
+
+// 生成各版本elasticsearch查询DSL并用官方客户端执行
+func Test_elastic_dsl(t *testing.T) {
+	zqlObj, err := New("", "select count(*) as c, avg(cost) from zu_hehe where (name = 'abc') and (time > now()-1h) group by time(5m), host order by c desc limit 10")
+	if err != nil {
+		t.Error(err)
+	}
+	str, err := zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `{"aggs":{"time":{"aggs":{"host":{"aggs":{"cost":{"avg":{"field":"cost"}}},"terms":{"field":"host","order":{"_count":"desc"},"size":10}}},"date_histogram":{"extended_bounds":{"max":"now","min":"now-1h"},"field":"date","fixed_interval":"5m","format":"yyyy-MM-dd HH:mm:ss","min_doc_count":0}}},"query":{"bool":{"must":[{"match_phrase":{"name":"abc"}},{"range":{"date":{"gt":"now-1h"}}}]}},"size":0}` {
+		t.Error("unexpected dsl:", str)
+	}
+	// GetElasticResult 默认使用2.x写法
+	zqlObj.Elastic = &ElasticOptions{Version: ElasticVersionLegacy}
+	str, _ = zqlObj.ElasticDSLStr()
+	if !strings.Contains(str, `"interval":"5m"`) {
+		t.Error("expected legacy interval:", str)
+	}
+	zqlObj.Elastic = nil
+	zqlObj, _ = New("", "select id as aid, name from zu_hehe where (id in (1, 2)) order by id desc limit 5, 10")
+	str, _ = zqlObj.ElasticDSLStr()
+	log.Println(str)
+	if str != `{"_source":["id","name"],"from":5,"query":{"bool":{"minimum_should_match":1,"should":[{"match_phrase":{"id":1}},{"match_phrase":{"id":2}}]}},"size":10,"sort":[{"id":{"order":"desc"}}]}` {
+		t.Error("unexpected dsl:", str)
+	}
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/zu_hehe/_search" {
+			t.Error("unexpected path:", req.URL.Path)
+		}
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprint(rec, `{"took":1,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"a1","_source":{"id":7,"name":"abc"}}]}}`)
+		return rec.Result(), nil
+	})
+	rows, err := zqlObj.GetElasticsearchQuery(context.Background(), transport, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["aid"] != int64(7) || rows[0]["_id"] != "a1" {
+		t.Error("unexpected rows:", rows)
+	}
+	// 嵌套字段的别名替换原字段
+	zqlObj, _ = New("", "select user.name as n, user.age from zu_hehe")
+	resp := map[string]interface{}{"hits": map[string]interface{}{"hits": []interface{}{
+		map[string]interface{}{"_id": "a2", "_source": map[string]interface{}{"user": map[string]interface{}{"name": "abc", "age": 3}}},
+	}}}
+	rows, err = zqlObj.elasticRows(resp, zqlObj.elasticOptions(ElasticVersionDefault))
+	if err != nil {
+		t.Error(err)
+	}
+	if str = fmt.Sprint(rows); str != "[map[_id:a2 n:abc user:map[age:3]]]" {
+		t.Error("unexpected rows:", str)
+	}
+	// 错误响应不是json时返回http状态
+	transport = esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "text/html")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		rec.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(rec, "<html>Bad Gateway</html>")
+		return rec.Result(), nil
+	})
+	if _, err = zqlObj.GetElasticsearchQuery(context.Background(), transport, ""); err == nil || err.Error() != "Elasticsearch error: 502 Bad Gateway" {
+		t.Error("unexpected error:", err)
+	}
+}
+
+// elasticsearch PIT和search_after游标分页
+func Test_elastic_cursor(t *testing.T) {
+	zqlObj, err := New("", "select * from logs order by time desc limit 2")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Elastic = &ElasticOptions{Version: 8}
+	closed := false
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		body := make(map[string]interface{})
+		if req.Body != nil {
+			decoder := json.NewDecoder(req.Body)
+			decoder.UseNumber()
+			decoder.Decode(&body)
+		}
+		switch {
+		case req.URL.Path == "/logs/_pit":
+			if keepAlive := req.URL.Query().Get("keep_alive"); keepAlive != "1m" {
+				t.Error("unexpected open pit keep_alive:", keepAlive)
+			}
+			fmt.Fprint(rec, `{"id":"pit1"}`)
+		case req.URL.Path == "/_pit" && req.Method == http.MethodDelete:
+			closed = body["id"] == "pit2"
+			fmt.Fprint(rec, `{"succeeded":true}`)
+		case req.URL.Path == "/_search" && body["search_after"] == nil:
+			if pit, _ := body["pit"].(map[string]interface{}); pit == nil || pit["keep_alive"] != "1m" {
+				t.Error("unexpected pit:", body["pit"])
+			}
+			fmt.Fprint(rec, `{"pit_id":"pit2","hits":{"hits":[{"_id":"1","_source":{"n":1},"sort":[1700000000000000001,5]},{"_id":"2","_source":{"n":2},"sort":[1700000000000000002,6]}]}}`)
+		case req.URL.Path == "/_search":
+			after := fmt.Sprint(body["search_after"])
+			if after != "[1700000000000000002 6]" {
+				t.Error("unexpected search_after:", after)
+			}
+			fmt.Fprint(rec, `{"pit_id":"pit2","hits":{"hits":[{"_id":"3","_source":{"n":3},"sort":[1700000000000000003,7]}]}}`)
+		default:
+			t.Error("unexpected request:", req.Method, req.URL.Path)
+		}
+		return rec.Result(), nil
+	})
+	page, err := zqlObj.GetElasticsearchPage(context.Background(), transport, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Rows) != 2 || page.Cursor == "" {
+		t.Fatal("unexpected first page:", page)
+	}
+	// 游标写在查询中，不区分大小写
+	zqlObj, err = New("", "select * from logs order by time desc limit 2 after '"+strings.ToUpper(page.Cursor)+"'")
+	if err != nil {
+		t.Error(err)
+	}
+	if zqlObj.Limit != "2" || strings.ToLower(zqlObj.After) != page.Cursor {
+		t.Error("unexpected after clause:", zqlObj.Limit, zqlObj.After)
+	}
+	zqlObj.Elastic = &ElasticOptions{Version: 8}
+	page, err = zqlObj.GetElasticsearchPage(context.Background(), transport, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Rows) != 1 || page.Cursor != "" || !closed {
+		t.Error("unexpected last page:", page, closed)
+	}
+}
+
+// elasticsearch composite聚合分页遍历全部分组
+func Test_elastic_composite(t *testing.T) {
+	zqlObj, err := New("", "select count(*) as c, sum(cost) as cost from zu_hehe group by time(1h), uid order by uid desc limit 2")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Elastic = &ElasticOptions{Composite: true}
+	str, _ := zqlObj.ElasticDSLStr()
+	log.Println(str)
+	if str != `{"aggs":{"group":{"aggs":{"cost":{"sum":{"field":"cost"}}},"composite":{"size":2,"sources":[{"time":{"date_histogram":{"field":"date","fixed_interval":"1h","format":"yyyy-MM-dd HH:mm:ss","missing_bucket":true}}},{"uid":{"terms":{"field":"uid","missing_bucket":true,"order":"desc"}}}]}}},"size":0}` {
+		t.Error("unexpected dsl:", str)
+	}
+	// 6.x 不确定是否支持missing_bucket
+	zqlObj.Elastic = &ElasticOptions{Composite: true, Version: 6}
+	if str, _ = zqlObj.ElasticDSLStr(); strings.Contains(str, "missing_bucket") {
+		t.Error("unexpected missing_bucket for 6.x:", str)
+	}
+	// 低版本不支持composite聚合
+	zqlObj.Elastic = &ElasticOptions{Composite: true, Version: 5}
+	if _, err = zqlObj.ElasticDSLStr(); err == nil {
+		t.Error("expected composite version error")
+	}
+	zqlObj.Elastic = &ElasticOptions{Composite: true}
+	pages := 0
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		body := make(map[string]interface{})
+		json.NewDecoder(req.Body).Decode(&body)
+		after := body["aggs"].(map[string]interface{})["group"].(map[string]interface{})["composite"].(map[string]interface{})["after"]
+		if pages == 0 {
+			fmt.Fprint(rec, `{"aggregations":{"group":{"after_key":{"time":"2017-01-01 01:00:00","uid":8},"buckets":[{"key":{"time":"2017-01-01 00:00:00","uid":9},"doc_count":3,"cost":{"value":1.5}},{"key":{"time":"2017-01-01 01:00:00","uid":8},"doc_count":1,"cost":{"value":2}}]}}}`)
+		} else {
+			if fmt.Sprint(after) != "map[time:2017-01-01 01:00:00 uid:8]" {
+				t.Error("unexpected after:", after)
+			}
+			fmt.Fprint(rec, `{"aggregations":{"group":{"after_key":{"time":"2017-01-01 02:00:00","uid":1},"buckets":[{"key":{"time":"2017-01-01 02:00:00","uid":1},"doc_count":5,"cost":{"value":0}}]}}}`)
+		}
+		pages++
+		return rec.Result(), nil
+	})
+	rows := make([]map[string]interface{}, 0)
+	for {
+		result, err := zqlObj.GetElasticsearchResult(context.Background(), transport, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, result.Rows...)
+		if result.Cursor == "" {
+			break
+		}
+		zqlObj.After = result.Cursor
+	}
+	log.Println(rows)
+	if pages != 2 || len(rows) != 3 || rows[0]["c"] != int64(3) || rows[0]["uid"] != int64(9) || rows[2]["cost"] != int64(0) {
+		t.Error("unexpected rows:", rows)
+	}
+}
+
+// elasticsearch全文检索函数和相关度排序
+func Test_elastic_search_func(t *testing.T) {
+	zqlObj, err := New("", "select title from news where (match(title, 'hello world')) and ((phrase(body, 'quick fox')) or (multi_match('fox', title, body))) and (term(status, 'Paid')) and (fuzzy(author, 'jhon', 2)) and (query_string('Title:Fox AND NOT body:dog*')) order by _score desc")
+	if err != nil {
+		t.Error(err)
+	}
+	str, err := zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `{"_source":["title"],"query":{"bool":{"must":[{"match":{"title":"hello world"}},{"bool":{"minimum_should_match":1,"should":[{"match_phrase":{"body":"quick fox"}},{"multi_match":{"fields":["title","body"],"query":"fox"}}]}},{"term":{"status":"Paid"}},{"fuzzy":{"author":{"fuzziness":2,"value":"jhon"}}},{"query_string":{"query":"Title:Fox AND NOT body:dog*"}}]}},"sort":[{"_score":{"order":"desc"}}]}` {
+		t.Error("unexpected dsl:", str)
+	}
+	// 只有一个全文检索条件时可以不加括号
+	zqlObj, _ = New("", "select * from news where match(title, 'fox')")
+	node, err := zqlObj.WhereTree()
+	if err != nil || node.Exp != "match" || node.Field != "title" || node.Value != "'fox'" {
+		t.Error("unexpected where node:", node, err)
+	}
+	if _, err = ParseWhere("(match(title))"); err == nil {
+		t.Error("expected arguments error")
+	}
+}
+
+// elasticsearch结果中的命中总数、耗时、分片失败和未返回分组的文档数
+func Test_elastic_result(t *testing.T) {
+	zqlObj, err := New("", "select count(*) as c from logs group by host, level limit 1")
+	if err != nil {
+		t.Error(err)
+	}
+	body := `{"took":12,"timed_out":true,"_shards":{"total":3,"successful":2,"skipped":0,"failed":1,"failures":[{"shard":1,"index":"logs","node":"n1","reason":{"type":"x","reason":"too many clauses"}}]},` +
+		`"hits":{"total":{"value":10000,"relation":"gte"},"hits":[]},` +
+		`"aggregations":{"host":{"sum_other_doc_count":40,"buckets":[{"key":"web-1","doc_count":60,"level":{"sum_other_doc_count":5,"buckets":[{"key":"error","doc_count":55}]}}]}}}`
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprint(rec, body)
+		return rec.Result(), nil
+	})
+	result, err := zqlObj.GetElasticsearchResult(context.Background(), transport, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 10000 || result.TotalRelation != ElasticRelationGte || result.Took != 12 || !result.TimedOut {
+		t.Error("unexpected result:", result)
+	}
+	if result.Shards.Failed != 1 || len(result.Shards.Failures) != 1 || result.Shards.Failures[0].Reason != "too many clauses" {
+		t.Error("unexpected shards:", result.Shards)
+	}
+	if result.OtherDocCount["host"] != 40 || result.OtherDocCount["level"] != 5 {
+		t.Error("unexpected other doc count:", result.OtherDocCount)
+	}
+	if len(result.Rows) != 1 || result.Rows[0]["c"] != int64(55) {
+		t.Error("unexpected rows:", result.Rows)
+	}
+	// elastic.v3 客户端同样返回分片失败信息
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/logs/logs/_search" {
+			t.Error("unexpected path:", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = zqlObj.GetElasticResult(client, "logs", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Shards.Failed != 1 || len(result.Shards.Failures) != 1 || result.Shards.Failures[0].Node != "n1" || result.Total != 10000 {
+		t.Error("unexpected v3 result:", result.Shards, result.Total)
+	}
+}
+
+// elasticsearch高亮
+func Test_elastic_highlight(t *testing.T) {
+	zqlObj, err := New("", "select id, highlight(title, body) as hl from news where (match(title, 'fox')) limit 10")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Elastic = &ElasticOptions{HighlightPreTag: "<b>", HighlightPostTag: "</b>", HighlightFragmentSize: 50}
+	str, err := zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `{"_source":["id"],"highlight":{"fields":{"body":{},"title":{}},"fragment_size":50,"post_tags":["</b>"],"pre_tags":["<b>"]},"query":{"match":{"title":"fox"}},"size":10}` {
+		t.Error("unexpected dsl:", str)
+	}
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprint(rec, `{"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"n1","_score":1.5,"_source":{"id":3},"highlight":{"title":["quick <b>fox</b>"]}}]}}`)
+		return rec.Result(), nil
+	})
+	rows, err := zqlObj.GetElasticsearchQuery(context.Background(), transport, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hl, _ := rows[0]["hl"].(map[string]interface{})
+	if list, _ := hl["title"].([]interface{}); len(list) != 1 || list[0] != "quick <b>fox</b>" {
+		t.Error("unexpected rows:", rows)
+	}
+	// 只有高亮时返回全部字段，默认列名为_highlight
+	zqlObj, _ = New("", "select highlight(*) from news")
+	str, _ = zqlObj.ElasticDSLStr()
+	if str != `{"highlight":{"fields":{"*":{}}}}` {
+		t.Error("unexpected dsl:", str)
+	}
+	fields, _ := zqlObj.ParseSelect()
+	if fields[0].Name() != ElasticHighlightKey {
+		t.Error("unexpected highlight name:", fields[0].Name())
+	}
+	// * 之后的高亮字段仍然生效
+	zqlObj, _ = New("", "select *, highlight(msg) from news")
+	str, _ = zqlObj.ElasticDSLStr()
+	if str != `{"highlight":{"fields":{"msg":{}}}}` {
+		t.Error("unexpected dsl:", str)
+	}
+}
+
+// elasticsearch按时间范围展开索引模板
+func Test_elastic_indices(t *testing.T) {
+	zqlObj, err := New("", "select * from logs where (time >= date('2026-10-16 10:00:00')) and (time < date('2026-10-18 01:00:00'))")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Elastic = &ElasticOptions{IndexPattern: "{prefix}{from}-{yyyy.MM.dd}", IndexLocation: time.Local}
+	indices, err := zqlObj.ElasticIndices()
+	if err != nil {
+		t.Error(err)
+	}
+	if strings.Join(indices, ",") != "logs-2026.10.16,logs-2026.10.17,logs-2026.10.18" {
+		t.Error("unexpected indices:", indices)
+	}
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path != "/logs-2026.10.16,logs-2026.10.17,logs-2026.10.18/_search" || req.URL.Query().Get("ignore_unavailable") != "true" {
+			t.Error("unexpected request:", req.URL)
+		}
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprint(rec, `{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`)
+		return rec.Result(), nil
+	})
+	if _, err = zqlObj.GetElasticsearchQuery(context.Background(), transport, ""); err != nil {
+		t.Error(err)
+	}
+	// 没有开始时间时使用通配符
+	zqlObj, _ = New("", "select * from logs where (host = 'web-1')")
+	zqlObj.Elastic = &ElasticOptions{IndexPattern: "{prefix}{from}-{yyyy.MM}"}
+	indices, _ = zqlObj.ElasticIndices()
+	if strings.Join(indices, ",") != "logs-*" {
+		t.Error("unexpected indices:", indices)
+	}
+}
+
+// elasticsearch nested字段的条件和分组
+func Test_elastic_nested(t *testing.T) {
+	mapping := make(map[string]interface{})
+	json.Unmarshal([]byte(`{"orders":{"mappings":{"properties":{"status":{"type":"keyword"},"items":{"type":"nested","properties":{"sku":{"type":"keyword"},"qty":{"type":"integer"}}}}}}}`), &mapping)
+	zqlObj, err := New("", "select * from orders where (items.sku = 'a1') and (status = 'paid') and (items.qty > 2)")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	str, err := zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `{"query":{"bool":{"must":[{"nested":{"path":"items","query":{"bool":{"must":[{"match_phrase":{"items.sku":"a1"}},{"range":{"items.qty":{"gt":2}}}]}}}},{"match_phrase":{"status":"paid"}}]}}}` {
+		t.Error("unexpected dsl:", str)
+	}
+	// mapping只在第一次使用时展开
+	if zqlObj.Elastic.fields == nil || zqlObj.elasticOptions(ElasticVersionDefault).fields["items.sku"] == nil {
+		t.Error("mapping fields are not cached")
+	}
+	SetElasticOptions("orders", &ElasticOptions{Mapping: mapping})
+	if fields := elasticOptionsMap["orders"].fields; fields == nil || fields["items.qty"] == nil {
+		t.Error("mapping fields are not flattened when set:", fields)
+	}
+	SetElasticOptions("orders", nil)
+	// 按nested字段分组，聚合普通字段和count(*)时回到根文档
+	zqlObj, _ = New("", "select sum(items.qty) as q, count(*) as c from orders group by status, items.sku order by q desc")
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	str, err = zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `{"aggs":{"status":{"aggs":{"items.sku_nested0":{"aggs":{"items.sku":{"aggs":{"q":{"sum":{"field":"items.qty"}},"root_doc_count":{"reverse_nested":{}}},"terms":{"field":"items.sku","order":{"q":"desc"},"size":100}}},"nested":{"path":"items"}}},"terms":{"field":"status","size":100}}},"size":0}` {
+		t.Error("unexpected dsl:", str)
+	}
+	transport := esTransportFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprint(rec, `{"hits":{"total":{"value":3,"relation":"eq"},"hits":[]},"aggregations":{"status":{"buckets":[{"key":"paid","doc_count":3,"items.sku_nested0":{"doc_count":5,"items.sku":{"buckets":[{"key":"a1","doc_count":4,"q":{"value":9},"root_doc_count":{"doc_count":2}}]}}}]}}}`)
+		return rec.Result(), nil
+	})
+	rows, err := zqlObj.GetElasticsearchQuery(context.Background(), transport, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["status"] != "paid" || rows[0]["items.sku"] != "a1" || rows[0]["q"] != int64(9) || rows[0]["c"] != int64(2) {
+		t.Error("unexpected rows:", rows)
+	}
+	// 普通分组中聚合nested字段
+	zqlObj, _ = New("", "select avg(items.qty) as q from orders group by status order by q desc")
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	str, _ = zqlObj.ElasticDSLStr()
+	if !strings.Contains(str, `"order":{"q_nested0>q":"desc"}`) {
+		t.Error("unexpected dsl:", str)
+	}
+	// 按count(*)排序时使用根文档数
+	zqlObj, _ = New("", "select count(*) as c from orders group by items.sku order by c desc")
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	str, _ = zqlObj.ElasticDSLStr()
+	if !strings.Contains(str, `"order":{"root_doc_count":"desc"}`) {
+		t.Error("unexpected dsl:", str)
+	}
+}
+
+// elasticsearch text字段使用keyword子字段分组、排序和精确匹配
+func Test_elastic_keyword(t *testing.T) {
+	mapping := make(map[string]interface{})
+	json.Unmarshal([]byte(`{"properties":{"host":{"type":"text","fields":{"raw":{"type":"keyword"}}},"msg":{"type":"text"},"level":{"type":"keyword"}}}`), &mapping)
+	zqlObj, err := New("", "select count(*) as c from logs where (host = 'Web-1') and (msg = 'timeout') and (level in ('warn', 'error')) group by host order by host")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	str, err := zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	// keyword子字段区分大小写，term使用原始的值
+	if str != `{"aggs":{"host":{"terms":{"field":"host.raw","order":{"_key":"asc"},"size":100}}},"query":{"bool":{"must":[{"term":{"host.raw":"Web-1"}},{"match_phrase":{"msg":"timeout"}},{"bool":{"minimum_should_match":1,"should":[{"match_phrase":{"level":"warn"}},{"match_phrase":{"level":"error"}}]}}]}},"size":0}` {
+		t.Error("unexpected dsl:", str)
+	}
+	zqlObj, _ = New("", "select * from logs order by host desc")
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	if str, _ = zqlObj.ElasticDSLStr(); !strings.Contains(str, `"sort":[{"host.raw":{"order":"desc"}}]`) {
+		t.Error("unexpected dsl:", str)
+	}
+	// 没有keyword子字段的text字段不能分组
+	zqlObj, _ = New("", "select count(*) from logs group by msg")
+	zqlObj.Elastic = &ElasticOptions{Mapping: mapping}
+	if _, err = zqlObj.ElasticDSLStr(); err == nil || !strings.Contains(err.Error(), "keyword") {
+		t.Error("expected keyword error:", err)
+	}
+}
+
+// elasticsearch按时间分组的字段、间隔类型、范围和格式
+func Test_elastic_histogram(t *testing.T) {
+	zqlObj, err := New("", "select count(*) as c from logs where (time >= date('2026-10-18 00:00:00')) and (time < date('2026-10-20 00:00:00')) group by time(1d, field=@timestamp, type=calendar)")
+	if err != nil {
+		t.Error(err)
+	}
+	if _, interval := zqlObj.ParseGroupBy(); interval != "1d" {
+		t.Error("unexpected interval:", interval)
+	}
+	zqlObj.Elastic = &ElasticOptions{TimeField: "@timestamp", HistogramFormat: "yyyy-MM-dd", HistogramTimeZone: "+08:00"}
+	str, err := zqlObj.ElasticDSLStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	start, _ := time.ParseInLocation("2006-01-02", "2026-10-18", time.Local)
+	end := start.AddDate(0, 0, 2)
+	expected := fmt.Sprintf(`{"aggs":{"time":{"date_histogram":{"calendar_interval":"1d","extended_bounds":{"max":%d,"min":%d},"field":"@timestamp","format":"yyyy-MM-dd","min_doc_count":0,"time_zone":"+08:00"}}},"query":{"bool":{"must":[{"range":{"@timestamp":{"gte":"%s"}}},{"range":{"@timestamp":{"lt":"%s"}}}]}},"size":0}`,
+		end.UnixNano()/int64(time.Millisecond), start.UnixNano()/int64(time.Millisecond), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	if str != expected {
+		t.Error("unexpected dsl:", str)
+	}
+	// 不返回空分组，日历间隔只能为1个单位
+	zqlObj, _ = New("", "select count(*) from logs group by time(1m, type=fixed)")
+	zqlObj.Elastic = &ElasticOptions{HistogramSkipEmpty: true}
+	if str, _ = zqlObj.ElasticDSLStr(); strings.Contains(str, "min_doc_count") || !strings.Contains(str, `"fixed_interval":"1m"`) {
+		t.Error("unexpected dsl:", str)
+	}
+	zqlObj, _ = New("", "select count(*) from logs group by time(2d, type=calendar)")
+	if _, err = zqlObj.ElasticDSLStr(); err == nil {
+		t.Error("expected calendar interval error")
+	}
+	// 不支持空分组填充
+	zqlObj, _ = New("", "select count(*) from logs group by time(1h) fill(0)")
+	if _, err = zqlObj.ElasticDSLStr(); err == nil {
+		t.Error("expected fill error")
+	}
+	// 月、周、季度，查询转小写后月份写作mo
+	intervals := map[string]string{
+		"time(1mo)":                `"calendar_interval":"1M"`,
+		"time(1q)":                 `"calendar_interval":"1q"`,
+		"time(1w, type=calendar)":  `"calendar_interval":"1w"`,
+		"time(2w)":                 `"fixed_interval":"14d","format":"yyyy-MM-dd HH:mm:ss","offset":"4d"`,
+		"time(1M)":                 `"fixed_interval":"1m"`,
+		"time(1mo, type=calendar)": `"calendar_interval":"1M"`,
+	}
+	for group, expected := range intervals {
+		zqlObj, _ = New("", "select count(*) from logs group by "+group)
+		zqlObj.Elastic = &ElasticOptions{HistogramSkipEmpty: true}
+		if str, err = zqlObj.ElasticDSLStr(); err != nil || !strings.Contains(str, expected) {
+			t.Error("unexpected dsl:", group, str, err)
+		}
+	}
+	zqlObj, _ = New("", "select count(*) from logs group by time(1mo, type=fixed)")
+	if _, err = zqlObj.ElasticDSLStr(); err == nil {
+		t.Error("expected fixed interval error")
+	}
+	zqlObj, _ = New("", "select count(*) from logs where (time > now()-1mo) group by time(1mo)")
+	zqlObj.Elastic = &ElasticOptions{Version: 6, HistogramSkipEmpty: true}
+	if str, _ = zqlObj.ElasticDSLStr(); !strings.Contains(str, `"interval":"1M"`) || !strings.Contains(str, `"gt":"now-1M"`) {
+		t.Error("unexpected dsl:", str)
+	}
+	if sql, _ := zqlObj.GetElasticSqlStr(); !strings.Contains(sql, "HISTOGRAM(date, INTERVAL 1 MONTH)") {
+		t.Error("unexpected sql:", sql)
+	}
+}
+
+// 测试用的elasticsearch transport
+type esTransportFunc func(req *http.Request) (*http.Response, error)
+
+func (f esTransportFunc) Perform(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// 生成elasticsearch sql和opensearch ppl
+func Test_elastic_sql(t *testing.T) {
+	zqlObj, err := New("", "select count(*) as c, avg(cost) from zu_hehe where (name = 'abc') and ((id > 1) or (time > now()-1h)) group by time(5m) order by c desc limit 10")
+	if err != nil {
+		t.Error(err)
+	}
+	query, err := zqlObj.GetElasticSqlStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(query)
+	if query != `SELECT HISTOGRAM(date, INTERVAL 5 MINUTE) AS time, COUNT(*) AS c, AVG(cost) AS cost FROM "zu_hehe" WHERE name = 'abc' AND (id > 1 OR date > NOW() - INTERVAL 1 HOUR) GROUP BY HISTOGRAM(date, INTERVAL 5 MINUTE) ORDER BY c DESC LIMIT 10` {
+		t.Error("unexpected sql:", query)
+	}
+	ppl, err := zqlObj.GetOpenSearchPplStr()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(ppl)
+	if ppl != "source=`zu_hehe` | where name = 'abc' and (id > 1 or date > DATE_SUB(NOW(), INTERVAL 1 HOUR)) | stats count() as c, avg(cost) as cost by span(date, 5m) as time | sort - c | head 10" {
+		t.Error("unexpected ppl:", ppl)
+	}
+	// 特殊字段名加引号，别名和时间值
+	zqlObj, _ = New("", "select @ts as t, host from zu_hehe where (host like 'web%') and (time > date('2017-01-01 00:00:00')) order by @ts desc limit 5")
+	query, _ = zqlObj.GetElasticSqlStr()
+	if query != `SELECT "@ts" AS t, host FROM "zu_hehe" WHERE host LIKE 'web%' AND date > CAST('2017-01-01T00:00:00' AS DATETIME) ORDER BY "@ts" DESC LIMIT 5` {
+		t.Error("unexpected sql:", query)
+	}
+	ppl, _ = zqlObj.GetOpenSearchPplStr()
+	if ppl != "source=`zu_hehe` | where like(host, 'web%') and date > TIMESTAMP('2017-01-01 00:00:00') | sort - `@ts` | head 5 | fields `@ts`, host | rename `@ts` as t" {
+		t.Error("unexpected ppl:", ppl)
+	}
+	// select * 不加引号
+	zqlObj, _ = New("", "select * from zu_hehe limit 5")
+	if query, _ = zqlObj.GetElasticSqlStr(); query != `SELECT * FROM "zu_hehe" LIMIT 5` {
+		t.Error("unexpected sql:", query)
+	}
+	// Elasticsearch SQL 不支持偏移量
+	zqlObj, _ = New("", "select host from zu_hehe limit 5, 10")
+	if _, err := zqlObj.GetElasticSqlStr(); err == nil {
+		t.Error("expected error for limit offset")
+	}
+	// 分组查询中的普通字段必须是分组字段
+	zqlObj, _ = New("", "select name, count(*) from zu_hehe group by id")
+	if _, err := zqlObj.GetElasticSqlStr(); err == nil {
+		t.Error("expected error for non-grouped field")
+	}
+	// 时间字段使用配置，time()中指定的分组字段优先
+	zqlObj, _ = New("", "select count(*) as c from zu_hehe where (time > now()-1h) group by time(1h, field=created)")
+	zqlObj.Elastic = &ElasticOptions{TimeField: "@timestamp"}
+	query, _ = zqlObj.GetElasticSqlStr()
+	if query != `SELECT HISTOGRAM(created, INTERVAL 1 HOUR) AS time, COUNT(*) AS c FROM "zu_hehe" WHERE "@timestamp" > NOW() - INTERVAL 1 HOUR GROUP BY HISTOGRAM(created, INTERVAL 1 HOUR)` {
+		t.Error("unexpected sql:", query)
+	}
+	ppl, _ = zqlObj.GetOpenSearchPplStr()
+	if !strings.Contains(ppl, "where `@timestamp` > DATE_SUB(NOW(), INTERVAL 1 HOUR)") || !strings.Contains(ppl, "span(created, 1h)") {
+		t.Error("unexpected ppl:", ppl)
+	}
+	// 不支持空分组填充
+	zqlObj, _ = New("", "select count(*) from zu_hehe group by time(1h) fill(0)")
+	if _, err := zqlObj.GetElasticSqlStr(); err == nil {
+		t.Error("expected error for fill")
+	}
+	if _, err := zqlObj.GetOpenSearchPplStr(); err == nil {
+		t.Error("expected error for fill")
+	}
+}
+
+// where条件转lucene
+func Test_where_lucene(t *testing.T) {
+	zqlObj, err := New("", "select * from zu_hehe where (name = 'a \"b\"') and (id >= 10) and (id < 20) and (path like 'c:/log%') and ((status in (1, 2)) or (host != 'web-1'))")
+	if err != nil {
+		t.Error(err)
+	}
+	query, err := zqlObj.WhereToLucene()
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(query)
+	if query != `name:"a \"b\"" AND id:[10 TO 20} AND path:c\:\/log* AND (status:(1 OR 2) OR (*:* -host:"web-1"))` {
+		t.Error("unexpected lucene query:", query)
+	}
+	// like 中原有的*?按普通字符匹配
+	zqlObj, _ = New("", "select * from zu_hehe where (name like 'a*b?_%')")
+	if query, _ = zqlObj.WhereToLucene(); query != `name:a\*b\??*` {
+		t.Error("unexpected lucene query:", query)
+	}
+	// 时间字段使用配置
+	zqlObj, _ = New("", "select * from zu_hehe where (time > now()-1h)")
+	zqlObj.Elastic = &ElasticOptions{TimeField: "@timestamp"}
+	if query, _ = zqlObj.WhereToLucene(); query != `@timestamp:{now-1h TO *]` {
+		t.Error("unexpected lucene query:", query)
+	}
+}
+
+// 内存数据执行查询
+func Test_exec_memory(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"id": 1, "name": "abc", "cost": 10, "time": 60},
+		{"id": 2, "name": "abd", "cost": 20, "time": 120},
+		{"id": 3, "name": "xyz", "cost": 30, "time": 130},
+		{"id": 4, "name": "abe", "cost": 40.5, "time": 350},
+	}
+	zqlObj, err := New("", "select id as aid, name from cache where (name like 'ab%') and (id != 2) order by aid desc limit 0, 1")
+	if err != nil {
+		t.Error(err)
+	}
+	list, err := zqlObj.ExecMemory(rows)
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(list)
+	if len(list) != 1 || list[0]["aid"] != 4 {
+		t.Error("unexpected result:", list)
+	}
+	zqlObj, err = New("", "select count(*) as c, sum(cost) as total, max(name) from cache group by time(2m) order by c desc")
+	if err != nil {
+		t.Error(err)
+	}
+	list, err = zqlObj.ExecMemory(rows)
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(list)
+	if len(list) != 3 || list[0]["c"] != 2 || list[0]["total"] != 50.0 || list[0]["name"] != "xyz" {
+		t.Error("unexpected result:", list)
+	}
+	// 没有字段时范围比较不匹配，类型不同时只有!=匹配，数字字符串按字符串比较
+	rows = []map[string]interface{}{
+		{"id": 1, "x": 3},
+		{"id": 2},
+		{"id": 3, "x": "abc"},
+		{"id": 4, "x": 8},
+		{"id": 5, "x": "3", "s": "10"},
+	}
+	for query, ids := range map[string][]int{
+		"select id from cache where (s < '9')": {5},
+		"select id from cache where (x < 5)":   {1},
+		"select id from cache where (x <= 5)":  {1},
+		"select id from cache where (x > 5)":   {4},
+		"select id from cache where (x != 3)":  {2, 3, 4, 5},
+		"select id from cache where (x = 3)":   {1},
+	} {
+		zqlObj, _ = New("", query+" order by id")
+		list, err = zqlObj.ExecMemory(rows)
+		if err != nil {
+			t.Error(err)
+		}
+		got := make([]int, 0)
+		for _, v := range list {
+			got = append(got, v["id"].(int))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Error("unexpected result:", query, got)
+		}
+	}
+}
+
+// 执行influxdb查询
+func Test_exec_influxdb(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("db") != "test" {
+			w.Write([]byte(`{"results":[{"statement_id":0,"error":"database not found"}]}`))
+			return
+		}
+		// 分块返回
+		w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"cpu","tags":{"host":"a"},"columns":["time","mean"],"values":[[60,1.5]]}],"partial":true}]}` + "\n"))
+		w.Write([]byte(`{"results":[{"statement_id":0,"series":[{"name":"cpu","tags":{"host":"b"},"columns":["time","mean"],"values":[[60,2]]}]}]}` + "\n"))
+	}))
+	defer server.Close()
+	zqlObj, err := New("", "select mean(value) from cpu group by time(1m), host")
+	if err != nil {
+		t.Error(err)
+	}
+	conf := &InfluxdbConfig{Addr: server.URL, Database: "test", Epoch: "s", ChunkSize: 100}
+	list, err := zqlObj.ExecInfluxdb(nil, conf, "")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(list)
+	if len(list) != 2 || list[1]["host"] != "b" || list[1]["mean"] != int64(2) || list[0]["time"] != time.Unix(60, 0) {
+		t.Error("unexpected result:", list)
+	}
+	// 没有epoch时RFC3339字符串同样转为time.Time
+	series := influxdbSeries{Columns: []string{"time", "mean"}}
+	row := influxdbRow(series, []interface{}{"1970-01-01T00:01:00Z", json.Number("2")}, "")
+	if tm, ok := row["time"].(time.Time); !ok || !tm.Equal(time.Unix(60, 0)) {
+		t.Error("unexpected row:", row)
+	}
+	// 语句错误
+	conf.Database = "other"
+	if _, err := zqlObj.ExecInfluxdb(nil, conf, ""); err == nil || err.Error() != "Influxdb statement 0 error: database not found" {
+		t.Error("expected error, got", err)
+	}
+}
+
+// 统一结果集
+func Test_result_set(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"name": "a", "cost": 10},
+		{"name": "a", "cost": 20},
+		{"name": "b", "cost": 5},
+	}
+	zqlObj, err := New("", "select name, sum(cost) as total from cache group by name order by total desc")
+	if err != nil {
+		t.Error(err)
+	}
+	rs, err := zqlObj.MemoryResultSet(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	columns := rs.Columns()
+	if len(columns) != 2 || columns[0].Type != ColumnString || columns[1].Aggregate != "sum" || columns[1].Type != ColumnFloat {
+		t.Error("unexpected columns:", columns)
+	}
+	var name string
+	var total float64
+	for rs.Next() {
+		if err := rs.Scan(&name, &total); err != nil {
+			t.Error(err)
+		}
+		log.Println(name, total)
+	}
+	if rs.Err() != nil || name != "b" || total != 5 {
+		t.Error("unexpected last row:", name, total, rs.Err())
+	}
+	// 第一行为null时从后面的行推断类型
+	zqlObj, _ = New("", "select * from cache")
+	rs, err = zqlObj.MemoryResultSet([]map[string]interface{}{
+		{"name": "a", "cost": nil},
+		{"name": "b", "cost": 2, "tag": "x"},
+		{"name": "c", "cost": 2.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns = rs.Columns()
+	if len(columns) != 3 || columns[0].Name != "cost" || columns[0].Type != ColumnFloat || columns[2].Name != "tag" || columns[2].Type != ColumnString {
+		t.Error("unexpected columns:", columns)
+	}
+	if list, err := rs.All(); err != nil || len(list) != 3 {
+		t.Error("unexpected rows:", list, err)
+	}
+}
+
+// 官方驱动的查询条件和pipeline
+func Test_mongo_driver(t *testing.T) {
+	zqlObj, err := New("", "select id, name from zu_hehe where (id = 1) and (name = 'abc') order by id desc, name limit 10, 20")
+	if err != nil {
+		t.Error(err)
+	}
+	filter, opts, err := zqlObj.MongoDriverFind()
+	if err != nil {
+		t.Error(err)
+	}
+	js, _ := json.Marshal(filter)
+	log.Println(string(js), opts.Sort, *opts.Skip, *opts.Limit)
+	if *opts.Skip != 10 || *opts.Limit != 20 || len(opts.Sort.(bson.D)) != 2 {
+		t.Error("unexpected find options")
+	}
+	zqlObj, err = New("", "select count(*) as c from zu_hehe where (id > 1) group by name order by c desc")
+	if err != nil {
+		t.Error(err)
+	}
+	pipeline, err := zqlObj.MongoDriverPipeline()
+	if err != nil {
+		t.Error(err)
+	}
+	js, _ = json.Marshal(pipeline)
+	log.Println(string(js))
+	if len(pipeline) != 3 || pipeline[0][0].Key != "$match" || pipeline[2][0].Key != "$sort" {
+		t.Error("unexpected pipeline:", string(js))
+	}
+	// 游标数据中的时间转为time.Time
+	now := time.Unix(1500000000, 0)
+	cursor, err := mongo.NewCursorFromDocuments([]interface{}{bson.M{"_id": now, "c": 2}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := mongoDriverRows(context.Background(), cursor)
+	if err != nil || len(rows) != 1 || !rows[0]["_id"].(time.Time).Equal(now) || rows[0]["c"] != int32(2) {
+		t.Error("unexpected rows:", rows, err)
+	}
+}
+
+// 不需要数据库连接生成mongo shell查询
+func Test_mongo_shell(t *testing.T) {
+	zqlObj, err := New("t_", "select id, name from zu_hehe where (id = 1) order by id desc limit 10, 20")
+	if err != nil {
+		t.Error(err)
+	}
+	str, err := zqlObj.GetMongoQueryStr(nil, "201701")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `db.t_zu_hehe_201701.find({"$or": [{"$and": [{id: {"$eq": 1}}]}]}, {id: 1, name: 1}).sort({id: -1}).skip(10).limit(20)` {
+		t.Error("unexpected shell query:", str)
+	}
+	zqlObj, _ = New("", "select count(*) as c from zu_hehe group by name")
+	str, err = zqlObj.MongoShell("")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+}
+
+// 时间字段为Date类型时使用ISODate比较
+func Test_mongo_date(t *testing.T) {
+	SetMongoOptions("logs", &MongoOptions{TimeField: "created", TimeType: MongoTimeDate})
+	defer SetMongoOptions("logs", nil)
+	zqlObj, err := New("", "select count(*) as c from logs where (time >= date('2017-01-01 00:00:00')) group by time(5m)")
+	if err != nil {
+		t.Error(err)
+	}
+	pipeline, err := zqlObj.MongoPipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, _ := zqlObj.MongoShell("")
+	log.Println(str)
+	where := pipeline[0]["$match"].(bson.M)["$or"].([]bson.M)[0]["$and"].([]bson.M)[0]
+	if _, ok := where["created"].(bson.M)["$gte"].(time.Time); !ok {
+		t.Error("expected time.Time value:", where)
+	}
+	// 未配置的表使用秒级时间戳
+	zqlObj, _ = New("", "select * from zu_hehe where (time > now()-1h)")
+	find, err := zqlObj.MongoFind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	where = find.Filter["$or"].([]bson.M)[0]["$and"].([]bson.M)[0]
+	if _, ok := where["datetime"].(bson.M)["$gt"].(int64); !ok {
+		t.Error("expected unix seconds:", where)
+	}
+	// 按月分组使用$dateTrunc，时间戳先转为Date
+	zqlObj, _ = New("", "select count(*) as c from logs group by time(1mo)")
+	str, _ = zqlObj.MongoShell("")
+	if !strings.Contains(str, `{"$dateTrunc": {binSize: 1, date: "$created", unit: "month"}}`) {
+		t.Error("unexpected shell:", str)
+	}
+	zqlObj, _ = New("", "select count(*) as c from zu_hehe group by time(1q)")
+	str, _ = zqlObj.MongoShell("")
+	if !strings.Contains(str, `{"$dateTrunc": {binSize: 1, date: {"$toDate": {"$multiply": ["$datetime", 1000]}}, unit: "quarter"}}`) {
+		t.Error("unexpected shell:", str)
+	}
+	zqlObj.Mongo = &MongoOptions{ServerVersion: "4.4"}
+	if _, err = zqlObj.MongoPipeline(); err == nil {
+		t.Error("expected $dateTrunc version error")
+	}
+	// 按周分组从周一开始
+	zqlObj, _ = New("", "select count(*) as c from logs group by time(1w)")
+	str, _ = zqlObj.MongoShell("")
+	if !strings.Contains(str, `{"$mod": [{"$subtract": ["$created", ISODate("1970-01-05T00:00:00.000Z")]}, 604800000]}`) {
+		t.Error("unexpected shell:", str)
+	}
+	zqlObj, _ = New("", "select count(*) as c from zu_hehe group by time(1w)")
+	str, _ = zqlObj.MongoShell("")
+	if !strings.Contains(str, `{"$mod": [{"$subtract": ["$datetime", 345600]}, 604800]}`) {
+		t.Error("unexpected shell:", str)
+	}
+}
+
+// 按日历间隔计算分组开始时间
+func Test_interval_start(t *testing.T) {
+	date := time.Date(2017, 5, 20, 13, 14, 0, 0, time.UTC)
+	intervals := map[string]string{
+		"1mo": "2017-05-01",
+		"2mo": "2017-05-01",
+		"1q":  "2017-04-01",
+		"1y":  "2017-01-01",
+		"1w":  "2017-05-15",
+		"2w":  "2017-05-08",
+		"1d":  "2017-05-20",
+	}
+	for interval, expected := range intervals {
+		start, err := intervalStart(date, interval)
+		if err != nil || start.Format("2006-01-02") != expected {
+			t.Error("unexpected start:", interval, start, err)
+		}
+	}
+	if next := intervalNext(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), "1q"); next.Format("2006-01-02") != "2017-04-01" {
+		t.Error("unexpected next:", next)
+	}
+	// 内存查询按月分组
+	zqlObj, _ := New("", "select count(*) as c from logs group by time(1mo)")
+	rows := []map[string]interface{}{
+		{"time": time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"time": time.Date(2017, 1, 30, 0, 0, 0, 0, time.UTC)},
+		{"time": time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	list, err := zqlObj.ExecMemory(rows)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(list) != 2 || list[0]["c"] != 2 || list[1]["c"] != 1 {
+		t.Error("unexpected rows:", list)
+	}
+}
+
+// mongodb按时间分组填充空分组
+func Test_mongo_fill(t *testing.T) {
+	zqlObj, err := New("", "select sum(v) as v from logs where (time >= date('2017-01-01 00:00:00')) and (time < date('2017-01-01 00:20:00')) group by time(5m) fill(linear)")
+	if err != nil {
+		t.Error(err)
+	}
+	zqlObj.Mongo = &MongoOptions{ServerVersion: "6.0"}
+	pipeline, err := zqlObj.MongoPipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, _ := zqlObj.MongoShell("")
+	log.Println(str)
+	if pipeline[2]["$densify"] == nil || pipeline[3]["$fill"] == nil || pipeline[4]["$addFields"] == nil {
+		t.Error("expected $densify and $fill:", str)
+	}
+	// 低版本查询后填充，分页也在填充后处理
+	zqlObj.Mongo = &MongoOptions{ServerVersion: "4.4"}
+	zqlObj.Limit = "2"
+	pipeline, _ = zqlObj.MongoPipeline()
+	for _, v := range pipeline {
+		if v["$densify"] != nil || v["$limit"] != nil {
+			t.Error("unexpected $densify or $limit for old server")
+		}
+	}
+	str, _ = zqlObj.MongoShell("")
+	if !strings.HasSuffix(str, ") /* fill(linear), limit 2 applied by MongoFillRows */") {
+		t.Error("expected post fill note:", str)
+	}
+	zqlObj.Limit = ""
+	start, _ := time.ParseInLocation("2006-01-02 15:04:05", "2017-01-01 00:00:00", time.Local)
+	start, _ = intervalStart(start, "5m")
+	rows := []map[string]interface{}{
+		{"_id": start, "time": start, "v": 10},
+		{"_id": start.Add(15 * time.Minute), "time": start.Add(15 * time.Minute), "v": 40},
+	}
+	list, err := zqlObj.MongoFillRows(rows)
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(list)
+	if len(list) != 4 || list[1]["v"] != 20.0 || list[2]["v"] != 30.0 {
+		t.Error("unexpected fill rows:", list)
+	}
+}
+
+// mongodb条件值类型转换
+func Test_mongo_value(t *testing.T) {
+	SetMongoOptions("users", &MongoOptions{Schema: map[string]string{"code": "string", "score": "float"}})
+	defer SetMongoOptions("users", nil)
+	zqlObj, err := New("", "select * from users where (id in (1, 2)) and (rate = 3.14) and (vip = true) and (deleted = null) and (_id = objectid('5a0b9b7e8f1c2a0001a1b2c3')) and (code = 007) and (score > 3)")
+	if err != nil {
+		t.Error(err)
+	}
+	find, err := zqlObj.MongoFind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, _ := zqlObj.MongoShell("")
+	log.Println(str)
+	where := find.Filter["$or"].([]bson.M)[0]["$and"].([]bson.M)
+	oid, _ := primitive.ObjectIDFromHex("5a0b9b7e8f1c2a0001a1b2c3")
+	expected := []interface{}{[]interface{}{1, 2}, 3.14, true, nil, oid, "007", 3.0}
+	for k, v := range where {
+		for _, cond := range v {
+			for _, val := range cond.(bson.M) {
+				if fmt.Sprintf("%#v", val) != fmt.Sprintf("%#v", expected[k]) {
+					t.Errorf("condition %d: expected %#v, got %#v", k, expected[k], val)
+				}
+			}
+		}
+	}
+	// mgo执行时转为mgo的bson类型
+	filter := toMgoValue(find.Filter).(mgobson.M)["$or"].([]interface{})[0].(mgobson.M)["$and"].([]interface{})
+	if id := filter[4].(mgobson.M)["_id"].(mgobson.M)["$eq"]; id != mgobson.ObjectIdHex("5a0b9b7e8f1c2a0001a1b2c3") {
+		t.Errorf("unexpected mgo objectid: %#v", id)
+	}
+}
+
+// 单引号中的值保持原始大小写，各数据源使用相同的值
+func Test_quoted_case(t *testing.T) {
+	str := lowerOutsideQuotes(`SELECT * FROM Logs WHERE (Msg = 'It\'s OK') AND (Host = 'Web-1')`)
+	if str != `select * from logs where (msg = 'It\'s OK') and (host = 'Web-1')` {
+		t.Error("unexpected query:", str)
+	}
+	zqlObj, err := New("", "SELECT * FROM Logs WHERE (Name = 'AbC') AND (Host = 'Web-1')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zqlObj.From != "logs" || zqlObj.Where != "(name = 'AbC') and (host = 'Web-1')" {
+		t.Error("unexpected query:", zqlObj.From, zqlObj.Where)
+	}
+	dsl, _ := zqlObj.ElasticDSLStr()
+	lucene, _ := zqlObj.WhereToLucene()
+	sqlStr, _ := zqlObj.GetElasticSqlStr()
+	influx, _ := zqlObj.GetInfluxdbQuery("")
+	mongoStr, _ := zqlObj.MongoShell("")
+	for _, v := range []string{dsl, lucene, sqlStr, influx, mongoStr} {
+		log.Println(v)
+		if !strings.Contains(v, "AbC") || !strings.Contains(v, "Web-1") {
+			t.Error("quoted value lost its case:", v)
+		}
+	}
+	list, err := zqlObj.ExecMemory([]map[string]interface{}{{"name": "AbC", "host": "Web-1"}, {"name": "abc", "host": "web-1"}})
+	if err != nil || len(list) != 1 || list[0]["name"] != "AbC" {
+		t.Error("unexpected rows:", list, err)
+	}
+}
+
+// mongodb like、ilike和正则条件
+func Test_mongo_like(t *testing.T) {
+	zqlObj, err := New("", "select * from users where (name like 'a.b%') and (nick ilike '_x') and (email regexp '^a.*@b') and (tag =~ '^[A-Z]\\D+$')")
+	if err != nil {
+		t.Error(err)
+	}
+	str, err := zqlObj.MongoShell("")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `db.users.find({"$or": [{"$and": [{name: {"$options": "s", "$regex": "^a\\.b.*$"}}, {nick: {"$options": "is", "$regex": "^.x$"}}, {email: {"$regex": "^a.*@b"}}, {tag: {"$regex": "^[A-Z]\\D+$"}}]}]})` {
+		t.Error("unexpected shell:", str)
+	}
+	// 正则保持原始大小写，like中的%匹配换行
+	rows := []map[string]interface{}{
+		{"name": "a.b\nc", "nick": "AX", "email": "a1@b", "tag": "Xyz"},
+		{"name": "axbc", "nick": "AX", "email": "a1@b", "tag": "Xyz"},
+		{"name": "a.bc", "nick": "AX", "email": "a1@b", "tag": "X12"},
+	}
+	list, err := zqlObj.ExecMemory(rows)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(list) != 1 || list[0]["name"] != "a.b\nc" {
+		t.Error("unexpected rows:", list)
+	}
+}
+
+// mongodb explain摘要和索引建议
+func Test_mongo_explain(t *testing.T) {
+	zqlObj, err := New("", "select * from events where (status = 1) and (time > now()-1h) and (uid in (1, 2)) order by score desc")
+	if err != nil {
+		t.Error(err)
+	}
+	advice, err := zqlObj.MongoIndexAdvice()
+	if err != nil {
+		t.Error(err)
+	}
+	str := mongoShellValue(advice)
+	log.Println(str)
+	if str != "{status: 1, uid: 1, score: -1, datetime: 1}" {
+		t.Error("unexpected index advice:", str)
+	}
+	raw := bson.M{
+		"queryPlanner":   bson.M{"winningPlan": bson.M{"stage": "SORT", "inputStage": bson.M{"stage": "COLLSCAN"}}},
+		"executionStats": bson.M{"nReturned": int32(5), "totalDocsExamined": int32(1000), "totalKeysExamined": int32(0)},
+	}
+	result := mongoExplainSummary(raw)
+	log.Println(result.WinningPlan, result.Warnings)
+	if result.WinningPlan != "SORT <- COLLSCAN" || len(result.Warnings) != 3 || result.DocsExamined != 1000 || result.Returned != 5 {
+		t.Error("unexpected explain summary:", result.WinningPlan, result.Warnings)
+	}
+}
+
+// mongodb默认排序和allowDiskUse
+func Test_mongo_default_sort(t *testing.T) {
+	SetMongoOptions("logs", &MongoOptions{DefaultSort: "-time", AllowDiskUse: true})
+	defer SetMongoOptions("logs", nil)
+	zqlObj, err := New("", "select * from logs where (level = 'error')")
+	if err != nil {
+		t.Error(err)
+	}
+	str, _ := zqlObj.MongoShell("")
+	log.Println(str)
+	if str != `db.logs.find({"$or": [{"$and": [{level: {"$eq": "error"}}]}]}).sort({datetime: -1})` {
+		t.Error("unexpected shell:", str)
+	}
+	zqlObj, err = New("", "select count(*) from logs group by level")
+	if err != nil {
+		t.Error(err)
+	}
+	str, _ = zqlObj.MongoShell("")
+	log.Println(str)
+	if !strings.HasSuffix(str, ", {allowDiskUse: true})") {
+		t.Error("expected allowDiskUse:", str)
+	}
+	zqlObj.Mongo = &MongoOptions{DefaultSort: MongoSortNatural}
+	zqlObj.GroupBy = ""
+	zqlObj.Select = "*"
+	str, _ = zqlObj.MongoShell("")
+	if str != `db.logs.find({}).sort({"$natural": 1})` {
+		t.Error("unexpected shell:", str)
+	}
+}
+
+// mongodb别名、计算字段和排除字段
+func Test_mongo_projection(t *testing.T) {
+	zqlObj, err := New("", "select id as aid, user.name, price * (qty + 1) as total from orders where (id > 1) order by total desc, aid limit 5")
+	if err != nil {
+		t.Error(err)
+	}
+	if !zqlObj.MongoUseAggregate() {
+		t.Error("expected aggregate for aliases")
+	}
+	str, err := zqlObj.MongoShell("")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `db.orders.aggregate([{"$match": {"$or": [{"$and": [{id: {"$gt": 1}}]}]}}, {"$addFields": {total: {"$multiply": ["$price", {"$add": ["$qty", 1]}]}}}, {"$sort": {total: -1, id: 1}}, {"$limit": 5}, {"$project": {aid: "$id", total: {"$multiply": ["$price", {"$add": ["$qty", 1]}]}, "user.name": 1}}])` {
+		t.Error("unexpected pipeline:", str)
+	}
+	zqlObj, _ = New("", "select * except password, salt from users")
+	str, err = zqlObj.MongoShell("")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `db.users.find({}, {password: 0, salt: 0})` {
+		t.Error("unexpected find:", str)
+	}
+}
+
+// mongodb关联查询
+func Test_mongo_join(t *testing.T) {
+	zqlObj, err := New("t_", "select e.id, u.name from events e left join users u on e.uid = u._id where (e.id > 1) order by e.id desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, err := zqlObj.MongoShell("201701")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `db.t_events_201701.aggregate([{"$match": {"$or": [{"$and": [{id: {"$gt": 1}}]}]}}, {"$lookup": {as: "u", foreignField: "_id", from: "t_users_201701", localField: "uid"}}, {"$unwind": {path: "$u", preserveNullAndEmptyArrays: true}}, {"$sort": {id: -1}}, {"$project": {id: 1, "u.name": 1}}])` {
+		t.Error("unexpected pipeline:", str)
+	}
+	// on 中有其它条件时使用pipeline写法，条件使用关联表字段时$match在$lookup之后
+	zqlObj, _ = New("", "select e.id, u.name from events e join users u on e.uid = u._id and u.status = 1 where (u.name = 'abc')")
+	str, err = zqlObj.MongoShell("")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if str != `db.events.aggregate([{"$lookup": {as: "u", from: "users", let: {v_uid: "$uid"}, pipeline: [{"$match": {"$expr": {"$and": [{"$eq": ["$$v_uid", "$_id"]}, {"$eq": ["$status", 1]}]}}}]}}, {"$unwind": {path: "$u", preserveNullAndEmptyArrays: false}}, {"$match": {"$or": [{"$and": [{"u.name": {"$eq": "abc"}}]}]}}, {"$project": {id: 1, "u.name": 1}}])` {
+		t.Error("unexpected pipeline:", str)
+	}
+	// 关联表字段等于常量时不能使用localField
+	zqlObj, _ = New("", "select e.id from events e join users u on u.vip = true")
+	str, err = zqlObj.MongoShell("")
+	if err != nil {
+		t.Error(err)
+	}
+	log.Println(str)
+	if !strings.Contains(str, `pipeline: [{"$match": {"$expr": {"$and": [{"$eq": ["$vip", true]}]}}}]`) || strings.Contains(str, "localField") {
+		t.Error("unexpected pipeline:", str)
+	}
+}